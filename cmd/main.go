@@ -1,21 +1,146 @@
 package main
 
 import (
+	"database/sql"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/Graynie/InkZen/internal/handlers"
+	"github.com/Graynie/InkZen/internal/models"
 	"github.com/Graynie/InkZen/internal/repository"
+	"github.com/Graynie/InkZen/internal/services"
 )
 
 func main() {
+	admin := flag.String("admin", "", "promueve a administrador al usuario con este email y termina")
+	flag.Parse()
+
 	db := repository.NewDatabase()
 	defer db.Close()
 
+	// inkzen migrar estado|aplicar|revertir [version]
+	if flag.Arg(0) == "migrar" {
+		err := comandoMigrar(db, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	repository.InitSchema(db)
 
-	router := handlers.NewRouter(db)
+	// Crear el primer administrador: registrarse en /register y luego
+	// ejecutar inkzen -admin <email>
+	if *admin != "" {
+		user, err := repository.GetUserByEmail(db, *admin)
+		if err != nil {
+			log.Fatal("Usuario no encontrado: ", *admin)
+		}
+
+		err = repository.ActualizarRol(db, user.ID, models.RolAdmin)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(*admin, "ahora es administrador (debe volver a iniciar sesión)")
+		return
+	}
+
+	// Biblioteca sincronizada por el escáner (INKZEN_BIBLIOTECA)
+	raiz := os.Getenv("INKZEN_BIBLIOTECA")
+	if raiz == "" {
+		raiz = "biblioteca"
+	}
+
+	intervalo := 15 * time.Minute
+	if v := os.Getenv("INKZEN_ESCANEO_INTERVALO"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("INKZEN_ESCANEO_INTERVALO inválido: ", err)
+		}
+		intervalo = d
+	}
+
+	escaner := services.NewEscaner(db, raiz)
+	escaner.Iniciar(intervalo)
+
+	router := handlers.NewRouter(db, escaner)
 
 	fmt.Println("Servidor corriendo en http://localhost:3000")
 	http.ListenAndServe(":3000", router)
 }
+
+func comandoMigrar(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("uso: inkzen migrar estado|aplicar|revertir [version]")
+	}
+
+	switch args[0] {
+	case "estado":
+		migraciones, err := repository.EstadoMigraciones(db)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migraciones {
+			estado := "pendiente"
+			if m.EstaAplicada() {
+				estado = "aplicada " + m.Aplicada.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", m.Version, m.Nombre, estado)
+		}
+
+	case "aplicar":
+		aplicadas, err := repository.Migrar(db)
+		for _, m := range aplicadas {
+			fmt.Printf("Aplicada %04d_%s\n", m.Version, m.Nombre)
+		}
+		if err != nil {
+			return err
+		}
+		if len(aplicadas) == 0 {
+			fmt.Println("No hay migraciones pendientes")
+		}
+
+	case "revertir":
+		// Sin versión se revierte solo la última aplicada
+		hasta := -1
+		if len(args) > 1 {
+			v, err := strconv.Atoi(args[1])
+			if err != nil || v < 0 {
+				return fmt.Errorf("versión inválida: %s", args[1])
+			}
+			hasta = v
+		} else {
+			migraciones, err := repository.EstadoMigraciones(db)
+			if err != nil {
+				return err
+			}
+			for _, m := range migraciones {
+				if m.EstaAplicada() {
+					hasta = m.Version - 1
+				}
+			}
+			if hasta < 0 {
+				fmt.Println("No hay migraciones aplicadas")
+				return nil
+			}
+		}
+
+		revertidas, err := repository.RevertirMigraciones(db, hasta)
+		for _, m := range revertidas {
+			fmt.Printf("Revertida %04d_%s\n", m.Version, m.Nombre)
+		}
+		return err
+
+	default:
+		return fmt.Errorf("subcomando desconocido: %s", args[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,905 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+	"github.com/Graynie/InkZen/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// Códigos de error de la API, estables para que los clientes los comparen
+const (
+	CodigoPeticionInvalida = "peticion_invalida"
+	CodigoNoAutorizado     = "no_autorizado"
+	CodigoProhibido        = "prohibido"
+	CodigoNoEncontrado     = "no_encontrado"
+	CodigoMetodoNoValido   = "metodo_no_permitido"
+	CodigoConflicto        = "conflicto"
+	CodigoErrorInterno     = "error_interno"
+)
+
+// Cuerpo de todas las respuestas de error de /api/v1
+type ErrorAPI struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Lista paginada: {"datos": [...], "paginacion": {...}}
+type ListaAPI struct {
+	Datos      interface{}   `json:"datos"`
+	Paginacion PaginacionAPI `json:"paginacion"`
+}
+
+type PaginacionAPI struct {
+	Pagina    int `json:"pagina"`
+	PorPagina int `json:"por_pagina"`
+	Total     int `json:"total"`
+	Paginas   int `json:"paginas"`
+}
+
+// Los modelos se serializan con sus nombres de Go en las rutas JSON
+// antiguas; /api/v1 usa estas copias con nombres en snake_case para no
+// cambiar aquellas respuestas
+
+type UsuarioAPI struct {
+	ID               int    `json:"id"`
+	Nombre           string `json:"nombre"`
+	Email            string `json:"email"`
+	Rol              string `json:"rol"`
+	DireccionLectura string `json:"direccion_lectura"`
+}
+
+func nuevoUsuarioAPI(u models.Usuario) UsuarioAPI {
+	return UsuarioAPI{
+		ID:               u.ID,
+		Nombre:           u.Nombre,
+		Email:            u.Email,
+		Rol:              u.Rol,
+		DireccionLectura: u.DireccionLectura,
+	}
+}
+
+// Cuerpo de POST /api/v1/usuarios
+type RegistroAPI struct {
+	Nombre   string `json:"nombre"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// En POST y PUT se ignoran id, capitulos_tot y portada
+type MangaAPI struct {
+	ID           int    `json:"id"`
+	Titulo       string `json:"titulo"`
+	Autor        string `json:"autor"`
+	Genero       string `json:"genero"`
+	Idioma       string `json:"idioma"`
+	Editorial    string `json:"editorial"`
+	Descripcion  string `json:"descripcion"`
+	CapitulosTot int    `json:"capitulos_tot"`
+	Disponible   bool   `json:"disponible"`
+	Portada      string `json:"portada"`
+}
+
+func nuevoMangaAPI(m models.Manga) MangaAPI {
+	return MangaAPI{
+		ID:           m.ID,
+		Titulo:       m.Titulo,
+		Autor:        m.Autor,
+		Genero:       m.Genero,
+		Idioma:       m.Idioma,
+		Editorial:    m.Editorial,
+		Descripcion:  m.Descripcion,
+		CapitulosTot: m.CapitulosTot,
+		Disponible:   m.Disponible,
+		Portada:      m.Portada,
+	}
+}
+
+func (m MangaAPI) modelo() models.Manga {
+	return models.Manga{
+		ID:           m.ID,
+		Titulo:       m.Titulo,
+		Autor:        m.Autor,
+		Genero:       m.Genero,
+		Idioma:       m.Idioma,
+		Editorial:    m.Editorial,
+		Descripcion:  m.Descripcion,
+		CapitulosTot: m.CapitulosTot,
+		Disponible:   m.Disponible,
+		Portada:      m.Portada,
+	}
+}
+
+type CapituloAPI struct {
+	ID               int       `json:"id"`
+	MangaID          int       `json:"manga_id"`
+	Numero           int       `json:"numero"`
+	Titulo           string    `json:"titulo"`
+	FechaPublicacion time.Time `json:"fecha_publicacion"`
+	PaginasTot       int       `json:"paginas_tot"`
+	Faltante         bool      `json:"faltante"`
+}
+
+func nuevoCapituloAPI(c models.Capitulo) CapituloAPI {
+	return CapituloAPI{
+		ID:               c.ID,
+		MangaID:          c.MangaID,
+		Numero:           c.Numero,
+		Titulo:           c.Titulo,
+		FechaPublicacion: c.FechaPublicacion,
+		PaginasTot:       c.PaginasTot,
+		Faltante:         c.Faltante,
+	}
+}
+
+// Página de un capítulo con la URL de su imagen
+type PaginaAPI struct {
+	ID         int    `json:"id"`
+	CapituloID int    `json:"capitulo_id"`
+	Numero     int    `json:"numero"`
+	Archivo    string `json:"archivo"`
+	URL        string `json:"url"`
+}
+
+type LecturaAPI struct {
+	ID             int       `json:"id"`
+	UsuarioID      int       `json:"usuario_id"`
+	MangaID        int       `json:"manga_id"`
+	CapituloActual int       `json:"capitulo_actual"`
+	PaginaActual   int       `json:"pagina_actual"`
+	Relecturas     int       `json:"relecturas"`
+	Estado         string    `json:"estado"`
+	Puntuacion     int       `json:"puntuacion"`
+	Iniciada       time.Time `json:"iniciada"`
+	UltimaLectura  time.Time `json:"ultima_lectura"`
+	Terminada      time.Time `json:"terminada"`
+}
+
+func nuevaLecturaAPI(l models.Lectura) LecturaAPI {
+	return LecturaAPI{
+		ID:             l.ID,
+		UsuarioID:      l.UsuarioID,
+		MangaID:        l.MangaID,
+		CapituloActual: l.CapituloActual,
+		PaginaActual:   l.PaginaActual,
+		Relecturas:     l.Relecturas,
+		Estado:         l.Estado,
+		Puntuacion:     l.Puntuacion,
+		Iniciada:       l.Iniciada,
+		UltimaLectura:  l.UltimaLectura,
+		Terminada:      l.Terminada,
+	}
+}
+
+// Lectura con los datos del manga, para la biblioteca
+type EntradaBibliotecaAPI struct {
+	LecturaAPI
+	Manga MangaAPI `json:"manga"`
+}
+
+// Token para usar como "Authorization: Bearer <token>"
+type SesionAPI struct {
+	Token   string     `json:"token"`
+	Usuario UsuarioAPI `json:"usuario"`
+}
+
+// Cuerpo de POST /api/v1/sesion
+type CredencialesAPI struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Cuerpo de PUT /api/v1/lecturas/{manga_id}
+type EstadoLecturaAPI struct {
+	Estado     string `json:"estado"`
+	Puntuacion int    `json:"puntuacion"`
+}
+
+// Cuerpo de PUT /api/v1/lecturas/{manga_id}/progreso
+type ProgresoAPI struct {
+	Capitulo int `json:"capitulo"`
+	Pagina   int `json:"pagina"`
+}
+
+// Cuerpo de PUT /api/v1/usuarios/{id}/rol
+type RolAPI struct {
+	Rol string `json:"rol"`
+}
+
+// Tamaño máximo de un cuerpo JSON
+const maxCuerpoAPI = 1 << 20
+
+// API JSON versionada. Todas las respuestas son JSON, también los errores
+// y las rutas que no existen
+func APIRouter(db *sql.DB) http.Handler {
+	r := chi.NewRouter()
+
+	userService := services.NewUsuarioService()
+
+	autenticado := apiAutenticado(db)
+	soloEditores := apiAutenticado(db, models.RolAdmin, models.RolEditor)
+	soloAdmin := apiAutenticado(db, models.RolAdmin)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		responderError(w, http.StatusNotFound, CodigoNoEncontrado, "Ruta no encontrada", nil)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		responderError(w, http.StatusMethodNotAllowed, CodigoMetodoNoValido, "Método no permitido", nil)
+	})
+
+	r.Post("/sesion", APISesionHandler(db))
+	r.Post("/usuarios", APICreateUsuarioHandler(db, userService))
+	r.With(autenticado).Get("/usuarios/yo", APIUsuarioActualHandler(db))
+	r.With(soloAdmin).Get("/usuarios", APIListUsuariosHandler(db))
+	r.With(soloAdmin).Put("/usuarios/{id}/rol", APIUpdateRolHandler(db))
+
+	r.Get("/mangas", APIListMangasHandler(db))
+	r.With(soloEditores).Post("/mangas", APICreateMangaHandler(db))
+	r.Get("/mangas/{id}", APIGetMangaHandler(db))
+	r.With(soloEditores).Put("/mangas/{id}", APIUpdateMangaHandler(db))
+	r.With(soloEditores).Delete("/mangas/{id}", APIDeleteMangaHandler(db))
+	r.Get("/mangas/{id}/capitulos", APIListCapitulosHandler(db))
+	r.Get("/mangas/{id}/capitulos/{numero}", APIGetCapituloHandler(db))
+	r.Get("/mangas/{id}/capitulos/{numero}/paginas", APIListPaginasHandler(db))
+
+	r.With(autenticado).Get("/lecturas", APIListLecturasHandler(db))
+	r.With(autenticado).Get("/lecturas/{manga_id}", APIGetLecturaHandler(db))
+	r.With(autenticado).Put("/lecturas/{manga_id}", APIUpdateLecturaHandler(db))
+	r.With(autenticado).Put("/lecturas/{manga_id}/progreso", APIProgresoHandler(db))
+
+	return r
+}
+
+func responderJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func responderError(w http.ResponseWriter, status int, code string, message string, details interface{}) {
+	responderJSON(w, status, ErrorAPI{Code: code, Message: message, Details: details})
+}
+
+func errorInterno(w http.ResponseWriter, message string) {
+	responderError(w, http.StatusInternalServerError, CodigoErrorInterno, message, nil)
+}
+
+// Decodifica el cuerpo en dst. Si no es JSON válido responde 400 y
+// devuelve false
+func leerJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCuerpoAPI))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		responderError(w, http.StatusBadRequest, CodigoPeticionInvalida, "JSON inválido", err.Error())
+		return false
+	}
+	return true
+}
+
+func nuevaLista(datos interface{}, pagina int, porPagina int, total int) ListaAPI {
+	return ListaAPI{
+		Datos: datos,
+		Paginacion: PaginacionAPI{
+			Pagina:    pagina,
+			PorPagina: porPagina,
+			Total:     total,
+			Paginas:   (total + porPagina - 1) / porPagina,
+		},
+	}
+}
+
+// Pagina en memoria una lista que el repositorio devuelve entera. Sin
+// ?pagina= ni ?por_pagina= es la primera página de 24, como el catálogo
+func paginarLista[T any](r *http.Request, items []T) ListaAPI {
+	pagina, _ := strconv.Atoi(r.URL.Query().Get("pagina"))
+	porPagina, _ := strconv.Atoi(r.URL.Query().Get("por_pagina"))
+
+	if pagina < 1 {
+		pagina = 1
+	}
+	if porPagina < 1 || porPagina > repository.MaxPorPagina {
+		porPagina = 24
+	}
+
+	desde := min((pagina-1)*porPagina, len(items))
+	hasta := min(desde+porPagina, len(items))
+
+	datos := items[desde:hasta]
+	if datos == nil {
+		datos = []T{}
+	}
+
+	return nuevaLista(datos, pagina, porPagina, len(items))
+}
+
+// Como RequireRol pero con errores JSON. Sin roles basta con tener sesión
+func apiAutenticado(db *sql.DB, roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			tokenString := tokenFromRequest(r)
+			if tokenString == "" {
+				responderError(w, http.StatusUnauthorized, CodigoNoAutorizado, "Token requerido", nil)
+				return
+			}
+
+			rol, err := rolDeToken(db, tokenString)
+			if errors.Is(err, errTokenInvalido) {
+				responderError(w, http.StatusUnauthorized, CodigoNoAutorizado, "Token inválido", nil)
+				return
+			}
+			if err != nil {
+				errorInterno(w, "Error comprobando permisos")
+				return
+			}
+
+			if len(roles) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			for _, permitido := range roles {
+				if rol == permitido {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			responderError(w, http.StatusForbidden, CodigoProhibido, "Permisos insuficientes", nil)
+		})
+	}
+}
+
+func APISesionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var credenciales CredencialesAPI
+		if !leerJSON(w, r, &credenciales) {
+			return
+		}
+
+		user, err := repository.GetUserByEmail(db, credenciales.Email)
+		if err == nil {
+			err = services.CheckPassword(user.Password, credenciales.Password)
+		}
+		if err != nil {
+			responderError(w, http.StatusUnauthorized, CodigoNoAutorizado, "Email o contraseña incorrectos", nil)
+			return
+		}
+
+		token, err := services.GenerateJWT(user.ID, user.Rol)
+		if err != nil {
+			errorInterno(w, "Error generando token")
+			return
+		}
+
+		user, err = repository.GetUsuario(db, user.ID)
+		if err != nil {
+			errorInterno(w, "Error obteniendo usuario")
+			return
+		}
+
+		responderJSON(w, http.StatusOK, SesionAPI{Token: token, Usuario: nuevoUsuarioAPI(user)})
+	}
+}
+
+func APICreateUsuarioHandler(db *sql.DB, userService *services.UsuarioService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var registro RegistroAPI
+		if !leerJSON(w, r, &registro) {
+			return
+		}
+
+		// El rol solo lo cambia un admin
+		user := models.Usuario{
+			Nombre:   registro.Nombre,
+			Email:    registro.Email,
+			Password: registro.Password,
+			Rol:      models.RolLector,
+		}
+
+		var faltan []string
+		for _, campo := range []struct{ nombre, valor string }{
+			{"nombre", user.Nombre},
+			{"email", user.Email},
+			{"password", user.Password},
+		} {
+			if strings.TrimSpace(campo.valor) == "" {
+				faltan = append(faltan, campo.nombre)
+			}
+		}
+		if len(faltan) > 0 {
+			responderError(w, http.StatusBadRequest, CodigoPeticionInvalida, "Faltan campos obligatorios", map[string][]string{"campos": faltan})
+			return
+		}
+
+		user, err := userService.PrepareUser(user)
+		if err != nil {
+			errorInterno(w, "Error procesando contraseña")
+			return
+		}
+
+		err = repository.CreateUser(db, user)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				responderError(w, http.StatusConflict, CodigoConflicto, "El email ya está registrado", nil)
+				return
+			}
+			errorInterno(w, "Error creando usuario")
+			return
+		}
+
+		creado, err := repository.GetUserByEmail(db, user.Email)
+		if err != nil {
+			errorInterno(w, "Error obteniendo usuario")
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/usuarios/%d", creado.ID))
+		responderJSON(w, http.StatusCreated, nuevoUsuarioAPI(creado))
+	}
+}
+
+func APIUsuarioActualHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, _ := getUserIDFromRequest(r)
+
+		user, err := repository.GetUsuario(db, usuarioID)
+		if errors.Is(err, sql.ErrNoRows) {
+			responderError(w, http.StatusNotFound, CodigoNoEncontrado, "Usuario no encontrado", nil)
+			return
+		}
+		if err != nil {
+			errorInterno(w, "Error obteniendo usuario")
+			return
+		}
+
+		responderJSON(w, http.StatusOK, nuevoUsuarioAPI(user))
+	}
+}
+
+func APIListUsuariosHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarios, err := repository.GetUsuarios(db)
+		if err != nil {
+			errorInterno(w, "Error consultando usuarios")
+			return
+		}
+
+		lista := []UsuarioAPI{}
+		for _, u := range usuarios {
+			lista = append(lista, nuevoUsuarioAPI(u))
+		}
+
+		responderJSON(w, http.StatusOK, paginarLista(r, lista))
+	}
+}
+
+func APIUpdateRolHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var body RolAPI
+		if !leerJSON(w, r, &body) {
+			return
+		}
+
+		if body.Rol != models.RolAdmin && body.Rol != models.RolEditor && body.Rol != models.RolLector {
+			responderError(w, http.StatusBadRequest, CodigoPeticionInvalida, "Rol inválido",
+				map[string][]string{"permitidos": {models.RolAdmin, models.RolEditor, models.RolLector}})
+			return
+		}
+
+		err := repository.ActualizarRol(db, usuarioID, body.Rol)
+		if errors.Is(err, sql.ErrNoRows) {
+			responderError(w, http.StatusNotFound, CodigoNoEncontrado, "Usuario no encontrado", nil)
+			return
+		}
+		if err != nil {
+			errorInterno(w, "Error actualizando rol")
+			return
+		}
+
+		user, err := repository.GetUsuario(db, usuarioID)
+		if err != nil {
+			errorInterno(w, "Error obteniendo usuario")
+			return
+		}
+
+		responderJSON(w, http.StatusOK, nuevoUsuarioAPI(user))
+	}
+}
+
+// Catálogo con los mismos filtros que /mangas-web: q, genero, idioma,
+// editorial, disponible, orden, pagina y por_pagina
+func APIListMangasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		filtro := filtroDesdeQuery(db, r)
+
+		resultados, total, err := repository.ListarMangas(db, filtro)
+		if err != nil {
+			errorInterno(w, "Error obteniendo mangas")
+			return
+		}
+
+		mangas := []MangaAPI{}
+		for _, res := range resultados {
+			mangas = append(mangas, nuevoMangaAPI(res.Manga))
+		}
+
+		responderJSON(w, http.StatusOK, nuevaLista(mangas, filtro.Pagina, filtro.PorPagina, total))
+	}
+}
+
+// Manga de la URL. Los no disponibles solo los ven admin y editores. Si
+// no existe responde 404 y devuelve false
+func mangaAPI(db *sql.DB, w http.ResponseWriter, r *http.Request, parametro string) (models.Manga, bool) {
+	mangaID, _ := strconv.Atoi(chi.URLParam(r, parametro))
+
+	manga, err := repository.GetMangaByID(db, mangaID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !manga.Disponible && !puedeEditar(db, r)) {
+		responderError(w, http.StatusNotFound, CodigoNoEncontrado, "Manga no encontrado", nil)
+		return manga, false
+	}
+	if err != nil {
+		errorInterno(w, "Error obteniendo manga")
+		return manga, false
+	}
+
+	return manga, true
+}
+
+// Título y autor son obligatorios, como en el formulario
+func validarMangaAPI(w http.ResponseWriter, manga models.Manga) bool {
+	var faltan []string
+	if strings.TrimSpace(manga.Titulo) == "" {
+		faltan = append(faltan, "titulo")
+	}
+	if strings.TrimSpace(manga.Autor) == "" {
+		faltan = append(faltan, "autor")
+	}
+
+	if len(faltan) > 0 {
+		responderError(w, http.StatusBadRequest, CodigoPeticionInvalida, "Título y autor son obligatorios", map[string][]string{"campos": faltan})
+		return false
+	}
+	return true
+}
+
+func APIGetMangaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		manga, ok := mangaAPI(db, w, r, "id")
+		if !ok {
+			return
+		}
+
+		responderJSON(w, http.StatusOK, nuevoMangaAPI(manga))
+	}
+}
+
+// Los capítulos y la portada no se crean ni se editan desde aquí
+func APICreateMangaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var cuerpo MangaAPI
+		if !leerJSON(w, r, &cuerpo) {
+			return
+		}
+
+		manga := cuerpo.modelo()
+		if !validarMangaAPI(w, manga) {
+			return
+		}
+
+		manga.CapitulosTot = 0
+		manga.Portada = ""
+
+		id, err := repository.CreateManga(db, manga)
+		if err != nil {
+			errorInterno(w, "Error guardando manga")
+			return
+		}
+
+		manga, err = repository.GetMangaByID(db, id)
+		if err != nil {
+			errorInterno(w, "Error obteniendo manga")
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/mangas/%d", id))
+		responderJSON(w, http.StatusCreated, nuevoMangaAPI(manga))
+	}
+}
+
+func APIUpdateMangaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		actual, ok := mangaAPI(db, w, r, "id")
+		if !ok {
+			return
+		}
+
+		var cuerpo MangaAPI
+		if !leerJSON(w, r, &cuerpo) {
+			return
+		}
+
+		manga := cuerpo.modelo()
+		if !validarMangaAPI(w, manga) {
+			return
+		}
+
+		manga.ID = actual.ID
+		manga.CapitulosTot = actual.CapitulosTot
+		manga.Portada = actual.Portada
+
+		err := repository.UpdateManga(db, manga)
+		if err != nil {
+			errorInterno(w, "Error guardando manga")
+			return
+		}
+
+		responderJSON(w, http.StatusOK, nuevoMangaAPI(manga))
+	}
+}
+
+func APIDeleteMangaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		manga, ok := mangaAPI(db, w, r, "id")
+		if !ok {
+			return
+		}
+
+		err := services.EliminarManga(db, manga.ID)
+		if err != nil {
+			errorInterno(w, "Error eliminando manga")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func APIListCapitulosHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		manga, ok := mangaAPI(db, w, r, "id")
+		if !ok {
+			return
+		}
+
+		capitulos, err := repository.GetCapitulosByManga(db, manga.ID)
+		if err != nil {
+			errorInterno(w, "Error obteniendo capítulos")
+			return
+		}
+
+		lista := []CapituloAPI{}
+		for _, c := range capitulos {
+			lista = append(lista, nuevoCapituloAPI(c))
+		}
+
+		responderJSON(w, http.StatusOK, paginarLista(r, lista))
+	}
+}
+
+// Capítulo de la URL. Si no existe responde 404 y devuelve false
+func capituloAPI(db *sql.DB, w http.ResponseWriter, r *http.Request) (models.Capitulo, bool) {
+	manga, ok := mangaAPI(db, w, r, "id")
+	if !ok {
+		return models.Capitulo{}, false
+	}
+
+	numero, _ := strconv.Atoi(chi.URLParam(r, "numero"))
+
+	capitulo, err := repository.GetCapitulo(db, manga.ID, numero)
+	if errors.Is(err, sql.ErrNoRows) {
+		responderError(w, http.StatusNotFound, CodigoNoEncontrado, "Capítulo no encontrado", nil)
+		return capitulo, false
+	}
+	if err != nil {
+		errorInterno(w, "Error obteniendo capítulo")
+		return capitulo, false
+	}
+
+	return capitulo, true
+}
+
+func APIGetCapituloHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		capitulo, ok := capituloAPI(db, w, r)
+		if !ok {
+			return
+		}
+
+		responderJSON(w, http.StatusOK, nuevoCapituloAPI(capitulo))
+	}
+}
+
+// Páginas del capítulo; url admite ?w= como el resto de imágenes
+func APIListPaginasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		capitulo, ok := capituloAPI(db, w, r)
+		if !ok {
+			return
+		}
+
+		paginas, err := repository.GetPaginasByCapitulo(db, capitulo.ID)
+		if err != nil {
+			errorInterno(w, "Error obteniendo páginas")
+			return
+		}
+
+		var lista []PaginaAPI
+		for _, p := range paginas {
+			lista = append(lista, PaginaAPI{
+				ID:         p.ID,
+				CapituloID: p.CapituloID,
+				Numero:     p.Numero,
+				Archivo:    p.Archivo,
+				URL:        urlImagen(p.Archivo),
+			})
+		}
+
+		responderJSON(w, http.StatusOK, paginarLista(r, lista))
+	}
+}
+
+// Biblioteca del usuario de la sesión, opcionalmente de un ?estado=
+func APIListLecturasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, _ := getUserIDFromRequest(r)
+
+		estado := r.URL.Query().Get("estado")
+		if estado != "" && !models.EstadoValido(estado) {
+			responderError(w, http.StatusBadRequest, CodigoPeticionInvalida, "Estado inválido",
+				map[string][]string{"permitidos": models.EstadosLectura})
+			return
+		}
+
+		entradas, err := repository.ObtenerBiblioteca(db, usuarioID)
+		if err != nil {
+			errorInterno(w, "Error obteniendo biblioteca")
+			return
+		}
+
+		var filtradas []EntradaBibliotecaAPI
+		for _, e := range entradas {
+			if estado == "" || e.Estado == estado {
+				filtradas = append(filtradas, EntradaBibliotecaAPI{
+					LecturaAPI: nuevaLecturaAPI(e.Lectura),
+					Manga:      nuevoMangaAPI(e.Manga),
+				})
+			}
+		}
+
+		responderJSON(w, http.StatusOK, paginarLista(r, filtradas))
+	}
+}
+
+// Lectura del usuario de la sesión para el manga de la URL
+func lecturaAPI(db *sql.DB, w http.ResponseWriter, usuarioID int, mangaID int) {
+	lectura, err := repository.GetLectura(db, usuarioID, mangaID)
+	if errors.Is(err, sql.ErrNoRows) {
+		responderError(w, http.StatusNotFound, CodigoNoEncontrado, "El manga no está en tu biblioteca", nil)
+		return
+	}
+	if err != nil {
+		errorInterno(w, "Error obteniendo lectura")
+		return
+	}
+
+	responderJSON(w, http.StatusOK, nuevaLecturaAPI(lectura))
+}
+
+func APIGetLecturaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, _ := getUserIDFromRequest(r)
+		mangaID, _ := strconv.Atoi(chi.URLParam(r, "manga_id"))
+
+		lecturaAPI(db, w, usuarioID, mangaID)
+	}
+}
+
+// Estado y puntuación; añade el manga a la biblioteca si no estaba
+func APIUpdateLecturaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, _ := getUserIDFromRequest(r)
+
+		manga, ok := mangaAPI(db, w, r, "manga_id")
+		if !ok {
+			return
+		}
+
+		var body EstadoLecturaAPI
+		if !leerJSON(w, r, &body) {
+			return
+		}
+
+		if !models.EstadoValido(body.Estado) {
+			responderError(w, http.StatusBadRequest, CodigoPeticionInvalida, "Estado inválido",
+				map[string][]string{"permitidos": models.EstadosLectura})
+			return
+		}
+		if body.Puntuacion < 0 || body.Puntuacion > models.MaxPuntuacion {
+			responderError(w, http.StatusBadRequest, CodigoPeticionInvalida,
+				fmt.Sprintf("La puntuación va de 0 a %d", models.MaxPuntuacion), nil)
+			return
+		}
+
+		err := repository.ActualizarEstadoLectura(db, usuarioID, manga.ID, body.Estado, body.Puntuacion)
+		if err != nil {
+			errorInterno(w, "Error guardando lectura")
+			return
+		}
+
+		lecturaAPI(db, w, usuarioID, manga.ID)
+	}
+}
+
+// Página alcanzada, igual que POST /lecturas/progreso del lector web
+func APIProgresoHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, _ := getUserIDFromRequest(r)
+
+		manga, ok := mangaAPI(db, w, r, "manga_id")
+		if !ok {
+			return
+		}
+
+		var progreso ProgresoAPI
+		if !leerJSON(w, r, &progreso) {
+			return
+		}
+
+		capitulo, err := repository.GetCapitulo(db, manga.ID, progreso.Capitulo)
+		if errors.Is(err, sql.ErrNoRows) {
+			responderError(w, http.StatusNotFound, CodigoNoEncontrado, "Capítulo no encontrado", nil)
+			return
+		}
+		if err != nil {
+			errorInterno(w, "Error obteniendo capítulo")
+			return
+		}
+
+		if progreso.Pagina < 1 || progreso.Pagina > capitulo.PaginasTot {
+			responderError(w, http.StatusBadRequest, CodigoPeticionInvalida, "Página inválida",
+				map[string]int{"paginas_tot": capitulo.PaginasTot})
+			return
+		}
+
+		terminado := terminaCapitulo(db, usuarioID, capitulo, progreso.Pagina, capitulo.PaginasTot)
+
+		err = repository.GuardarProgresoPagina(db, usuarioID, manga.ID, capitulo.Numero, progreso.Pagina, terminado)
+		if err != nil {
+			errorInterno(w, "Error guardando progreso")
+			return
+		}
+
+		lecturaAPI(db, w, usuarioID, manga.ID)
+	}
+}
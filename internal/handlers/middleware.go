@@ -1,9 +1,19 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
 	"github.com/Graynie/InkZen/internal/services"
 )
 
@@ -27,3 +37,255 @@ func JWTMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Token del header Authorization (API) o de la cookie auth_token (web)
+func tokenFromRequest(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader != "" {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+
+	cookie, err := r.Cookie("auth_token")
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// Solo deja pasar a usuarios autenticados con alguno de los roles indicados
+func RequireRol(db *sql.DB, roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			tokenString := tokenFromRequest(r)
+			if tokenString == "" {
+				// Desde el navegador lo natural es ir a iniciar sesión
+				if r.Method == http.MethodGet && r.Header.Get("Authorization") == "" {
+					http.Redirect(w, r, "/login", http.StatusSeeOther)
+					return
+				}
+				http.Error(w, "Token requerido", http.StatusUnauthorized)
+				return
+			}
+
+			rol, err := rolDeToken(db, tokenString)
+			if errors.Is(err, errTokenInvalido) {
+				http.Error(w, "Token inválido", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Error comprobando permisos", http.StatusInternalServerError)
+				return
+			}
+
+			for _, permitido := range roles {
+				if rol == permitido {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			http.Error(w, "Permisos insuficientes", http.StatusForbidden)
+		})
+	}
+}
+
+// El token no es válido o su usuario ya no existe
+var errTokenInvalido = errors.New("token inválido")
+
+// Rol actual del usuario del token. Se lee de la base de datos y no del
+// token para que quitar un rol o borrar al usuario surta efecto enseguida,
+// sin esperar a que el token caduque
+func rolDeToken(db *sql.DB, tokenString string) (string, error) {
+	userID, err := services.GetUserIDFromToken(tokenString)
+	if err != nil {
+		return "", errTokenInvalido
+	}
+
+	user, err := repository.GetUsuario(db, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errTokenInvalido
+	}
+	if err != nil {
+		return "", err
+	}
+	return user.Rol, nil
+}
+
+// Rol del usuario de la petición; vacío si no hay sesión
+func getRolFromRequest(db *sql.DB, r *http.Request) string {
+	tokenString := tokenFromRequest(r)
+	if tokenString == "" {
+		return ""
+	}
+
+	rol, err := rolDeToken(db, tokenString)
+	if err != nil {
+		return ""
+	}
+	return rol
+}
+
+func puedeEditar(db *sql.DB, r *http.Request) bool {
+	rol := getRolFromRequest(db, r)
+	return rol == models.RolAdmin || rol == models.RolEditor
+}
+
+// Credenciales Basic ya comprobadas. Las apps OPDS las mandan en cada
+// petición y bcrypt es demasiado lento para hacerlo por cada página.
+// Se guardan el hash y el rol con los que se comprobaron: si cambian,
+// la entrada deja de valer
+type sesionBasic struct {
+	token  string
+	hash   string
+	rol    string
+	caduca time.Time
+}
+
+// Intentos Basic fallidos de una IP dentro de la ventana actual
+type fallosBasic struct {
+	n     int
+	desde time.Time
+}
+
+const (
+	duracionSesionBasic = 10 * time.Minute
+	maxFallosBasic      = 5
+	ventanaFallosBasic  = time.Minute
+)
+
+var (
+	sesionesBasicMu sync.Mutex
+	sesionesBasic   = map[string]sesionBasic{}
+	fallosBasicIP   = map[string]fallosBasic{}
+)
+
+// Autenticación de las apps de lectura: acepta HTTP Basic con email y
+// contraseña o el JWT de siempre (header o cookie). Basic se convierte en
+// un token Bearer para que el resto de handlers no distingan el origen
+func RequireBasicOJWT(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if email, password, ok := r.BasicAuth(); ok {
+				ip := ipCliente(r)
+
+				// 🔹 Demasiados fallos: se corta antes de llegar a bcrypt
+				if espera := esperaBasic(ip); espera > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(espera.Seconds())+1))
+					http.Error(w, "Demasiados intentos", http.StatusTooManyRequests)
+					return
+				}
+
+				token, err := tokenDeBasic(db, email, password)
+				if err == nil {
+					r = r.Clone(r.Context())
+					r.Header.Set("Authorization", "Bearer "+token)
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				registrarFalloBasic(ip)
+			} else if tokenString := tokenFromRequest(r); tokenString != "" {
+				if _, err := services.ValidateJWT(tokenString); err == nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			w.Header().Set("WWW-Authenticate", `Basic realm="InkZen", charset="UTF-8"`)
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+		})
+	}
+}
+
+func tokenDeBasic(db *sql.DB, email string, password string) (string, error) {
+	suma := sha256.Sum256([]byte(email + "\x00" + password))
+	clave := hex.EncodeToString(suma[:])
+
+	sesionesBasicMu.Lock()
+	sesion, ok := sesionesBasic[clave]
+	sesionesBasicMu.Unlock()
+
+	user, err := repository.GetUserByEmail(db, email)
+	if err != nil {
+		return "", err
+	}
+
+	if ok && time.Now().Before(sesion.caduca) && sesion.hash == user.Password && sesion.rol == user.Rol {
+		return sesion.token, nil
+	}
+
+	err = services.CheckPassword(user.Password, password)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := services.GenerateJWT(user.ID, user.Rol)
+	if err != nil {
+		return "", err
+	}
+
+	ahora := time.Now()
+
+	sesionesBasicMu.Lock()
+	defer sesionesBasicMu.Unlock()
+
+	// Se aprovecha para tirar las entradas caducadas
+	for k, s := range sesionesBasic {
+		if ahora.After(s.caduca) {
+			delete(sesionesBasic, k)
+		}
+	}
+
+	sesionesBasic[clave] = sesionBasic{
+		token:  token,
+		hash:   user.Password,
+		rol:    user.Rol,
+		caduca: ahora.Add(duracionSesionBasic),
+	}
+
+	return token, nil
+}
+
+// Tiempo que le queda a la IP sin poder intentar Basic, o 0
+func esperaBasic(ip string) time.Duration {
+	sesionesBasicMu.Lock()
+	defer sesionesBasicMu.Unlock()
+
+	f, ok := fallosBasicIP[ip]
+	if !ok || f.n < maxFallosBasic {
+		return 0
+	}
+
+	return time.Until(f.desde.Add(ventanaFallosBasic))
+}
+
+func registrarFalloBasic(ip string) {
+	ahora := time.Now()
+
+	sesionesBasicMu.Lock()
+	defer sesionesBasicMu.Unlock()
+
+	for k, f := range fallosBasicIP {
+		if ahora.Sub(f.desde) > ventanaFallosBasic {
+			delete(fallosBasicIP, k)
+		}
+	}
+
+	f, ok := fallosBasicIP[ip]
+	if !ok {
+		f = fallosBasic{desde: ahora}
+	}
+	f.n++
+	fallosBasicIP[ip] = f
+}
+
+// IP de la conexión, sin el puerto
+func ipCliente(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
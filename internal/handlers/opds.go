@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+	"github.com/Graynie/InkZen/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// Tipos de documento de OPDS 1.2
+const (
+	tipoNavegacionOPDS  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	tipoAdquisicionOPDS = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	tipoOpenSearch      = "application/opensearchdescription+xml"
+)
+
+// Relaciones de enlace de OPDS
+const (
+	relAdquisicion = "http://opds-spec.org/acquisition"
+	relImagen      = "http://opds-spec.org/image"
+	relMiniatura   = "http://opds-spec.org/image/thumbnail"
+	// OPDS Page Streaming Extension: páginas sueltas por índice
+	relStreamPSE = "http://vaemendis.net/opds-pse/stream"
+)
+
+type feedOPDS struct {
+	XMLName      xml.Name      `xml:"feed"`
+	Xmlns        string        `xml:"xmlns,attr"`
+	XmlnsDC      string        `xml:"xmlns:dc,attr"`
+	XmlnsOS      string        `xml:"xmlns:opensearch,attr"`
+	XmlnsPSE     string        `xml:"xmlns:pse,attr"`
+	ID           string        `xml:"id"`
+	Titulo       string        `xml:"title"`
+	Actualizado  string        `xml:"updated"`
+	Autor        autorAtom     `xml:"author"`
+	Enlaces      []enlaceAtom  `xml:"link"`
+	TotalResults int           `xml:"opensearch:totalResults,omitempty"`
+	PorPagina    int           `xml:"opensearch:itemsPerPage,omitempty"`
+	Entradas     []entradaOPDS `xml:"entry"`
+}
+
+type entradaOPDS struct {
+	ID          string          `xml:"id"`
+	Titulo      string          `xml:"title"`
+	Actualizado string          `xml:"updated"`
+	Autores     []autorAtom     `xml:"author"`
+	Idioma      string          `xml:"dc:language,omitempty"`
+	Editorial   string          `xml:"dc:publisher,omitempty"`
+	Emitido     string          `xml:"dc:issued,omitempty"`
+	Categorias  []categoriaAtom `xml:"category"`
+	Contenido   *contenidoAtom  `xml:"content"`
+	Enlaces     []enlaceAtom    `xml:"link"`
+}
+
+type autorAtom struct {
+	Nombre string `xml:"name"`
+}
+
+type categoriaAtom struct {
+	Termino  string `xml:"term,attr"`
+	Etiqueta string `xml:"label,attr"`
+}
+
+type contenidoAtom struct {
+	Tipo  string `xml:"type,attr"`
+	Texto string `xml:",chardata"`
+}
+
+type enlaceAtom struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Tipo   string `xml:"type,attr,omitempty"`
+	Titulo string `xml:"title,attr,omitempty"`
+	// Número de páginas del enlace pse:stream
+	Paginas int `xml:"pse:count,attr,omitempty"`
+}
+
+// Feed vacío con los enlaces comunes a todo el catálogo
+func nuevoFeedOPDS(r *http.Request, id string, titulo string, tipo string) feedOPDS {
+	return feedOPDS{
+		Xmlns:       "http://www.w3.org/2005/Atom",
+		XmlnsDC:     "http://purl.org/dc/terms/",
+		XmlnsOS:     "http://a9.com/-/spec/opensearch/1.1/",
+		XmlnsPSE:    "http://vaemendis.net/opds-pse/ns",
+		ID:          "urn:inkzen:opds:" + id,
+		Titulo:      titulo,
+		Actualizado: fechaAtom(time.Now()),
+		Autor:       autorAtom{Nombre: "InkZen"},
+		Enlaces: []enlaceAtom{
+			{Rel: "self", Href: r.URL.RequestURI(), Tipo: tipo},
+			{Rel: "start", Href: "/opds", Tipo: tipoNavegacionOPDS},
+			{Rel: "search", Href: "/opds/opensearch.xml", Tipo: tipoOpenSearch},
+		},
+	}
+}
+
+func escribirFeedOPDS(w http.ResponseWriter, tipo string, feed feedOPDS) {
+	w.Header().Set("Content-Type", tipo+";charset=utf-8")
+
+	w.Write([]byte(xml.Header))
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
+
+func fechaAtom(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Entrada de navegación que lleva a otro feed
+func entradaNavegacion(id string, titulo string, href string, tipo string) entradaOPDS {
+	return entradaOPDS{
+		ID:          "urn:inkzen:opds:" + id,
+		Titulo:      titulo,
+		Actualizado: fechaAtom(time.Now()),
+		Enlaces:     []enlaceAtom{{Rel: "subsection", Href: href, Tipo: tipo}},
+	}
+}
+
+// Raíz del catálogo
+func OPDSRaizHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		feed := nuevoFeedOPDS(r, "raiz", "InkZen", tipoNavegacionOPDS)
+
+		feed.Entradas = []entradaOPDS{
+			entradaNavegacion("series", "Todas las series", "/opds/series", tipoAdquisicionOPDS),
+			entradaNavegacion("recientes", "Añadidos recientemente", "/opds/series?orden="+repository.OrdenRecientes, tipoAdquisicionOPDS),
+			entradaNavegacion("generos", "Por género", "/opds/generos", tipoNavegacionOPDS),
+			entradaNavegacion("idiomas", "Por idioma", "/opds/idiomas", tipoNavegacionOPDS),
+		}
+
+		escribirFeedOPDS(w, tipoNavegacionOPDS, feed)
+	}
+}
+
+// Descripción OpenSearch: las apps sustituyen {searchTerms}
+func OPDSOpenSearchHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Set("Content-Type", tipoOpenSearch+";charset=utf-8")
+
+		fmt.Fprint(w, xml.Header+`<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+  <ShortName>InkZen</ShortName>
+  <Description>Buscar en el catálogo de InkZen</Description>
+  <InputEncoding>UTF-8</InputEncoding>
+  <OutputEncoding>UTF-8</OutputEncoding>
+  <Url type="`+tipoAdquisicionOPDS+`" template="/opds/series?q={searchTerms}&amp;pagina={startPage?}"/>
+</OpenSearchDescription>
+`)
+	}
+}
+
+// Géneros e idiomas del catálogo, cada uno enlazando a sus series
+func OPDSGenerosHandler(db *sql.DB) http.HandlerFunc {
+	return opdsFiltroHandler(db, "generos", "Por género", "genero")
+}
+
+func OPDSIdiomasHandler(db *sql.DB) http.HandlerFunc {
+	return opdsFiltroHandler(db, "idiomas", "Por idioma", "idioma")
+}
+
+func opdsFiltroHandler(db *sql.DB, id string, titulo string, parametro string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		generos, idiomas, _, err := repository.OpcionesFiltro(db)
+		if err != nil {
+			http.Error(w, "Error obteniendo filtros", http.StatusInternalServerError)
+			return
+		}
+
+		valores := generos
+		if parametro == "idioma" {
+			valores = idiomas
+		}
+
+		feed := nuevoFeedOPDS(r, id, titulo, tipoNavegacionOPDS)
+
+		for _, valor := range valores {
+			href := "/opds/series?" + url.Values{parametro: {valor}}.Encode()
+			feed.Entradas = append(feed.Entradas,
+				entradaNavegacion(id+":"+url.QueryEscape(valor), valor, href, tipoAdquisicionOPDS))
+		}
+
+		escribirFeedOPDS(w, tipoNavegacionOPDS, feed)
+	}
+}
+
+// Series del catálogo con los mismos filtros, orden, búsqueda y
+// paginación que /mangas-web
+func OPDSSeriesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		pagina, err := listarCatalogo(db, filtroDesdeQuery(db, r))
+		if err != nil {
+			http.Error(w, "Error obteniendo mangas", http.StatusInternalServerError)
+			return
+		}
+
+		titulo := "Series"
+		if q := r.URL.Query().Get("q"); q != "" {
+			titulo = "Búsqueda: " + q
+		}
+
+		feed := nuevoFeedOPDS(r, "series", titulo, tipoAdquisicionOPDS)
+		feed.TotalResults = pagina.Total
+		feed.PorPagina = pagina.PorPagina
+
+		if pagina.Pagina > 1 {
+			feed.Enlaces = append(feed.Enlaces,
+				enlaceAtom{Rel: "first", Href: urlPagina(r, 1), Tipo: tipoAdquisicionOPDS},
+				enlaceAtom{Rel: "previous", Href: urlPagina(r, pagina.Pagina-1), Tipo: tipoAdquisicionOPDS},
+			)
+		}
+		if pagina.Pagina < pagina.Paginas {
+			feed.Enlaces = append(feed.Enlaces,
+				enlaceAtom{Rel: "next", Href: urlPagina(r, pagina.Pagina+1), Tipo: tipoAdquisicionOPDS},
+				enlaceAtom{Rel: "last", Href: urlPagina(r, pagina.Paginas), Tipo: tipoAdquisicionOPDS},
+			)
+		}
+
+		for _, m := range pagina.Mangas {
+			entrada := entradaManga(m.Manga)
+			entrada.Enlaces = append(entrada.Enlaces, enlaceAtom{
+				Rel:  "subsection",
+				Href: fmt.Sprintf("/opds/series/%d", m.ID),
+				Tipo: tipoAdquisicionOPDS,
+			})
+			feed.Entradas = append(feed.Entradas, entrada)
+		}
+
+		escribirFeedOPDS(w, tipoAdquisicionOPDS, feed)
+	}
+}
+
+// Datos de la serie comunes a su entrada en la lista y a su feed
+func entradaManga(manga models.Manga) entradaOPDS {
+	entrada := entradaOPDS{
+		ID:          fmt.Sprintf("urn:inkzen:manga:%d", manga.ID),
+		Titulo:      manga.Titulo,
+		Actualizado: fechaAtom(time.Now()),
+		Idioma:      services.CodigoIdioma(manga.Idioma),
+		Editorial:   manga.Editorial,
+		Enlaces:     enlacesImagen(manga.Portada),
+	}
+
+	if manga.Autor != "" {
+		entrada.Autores = []autorAtom{{Nombre: manga.Autor}}
+	}
+	if manga.Descripcion != "" {
+		entrada.Contenido = &contenidoAtom{Tipo: "text", Texto: manga.Descripcion}
+	}
+
+	for _, genero := range strings.Split(manga.Genero, ",") {
+		if genero = strings.TrimSpace(genero); genero != "" {
+			entrada.Categorias = append(entrada.Categorias, categoriaAtom{Termino: genero, Etiqueta: genero})
+		}
+	}
+
+	return entrada
+}
+
+// Portada a tamaño completo y miniatura
+func enlacesImagen(archivo string) []enlaceAtom {
+	imagen := urlImagen(archivo)
+	tipo := tipoImagen(archivo)
+
+	return []enlaceAtom{
+		{Rel: relImagen, Href: imagen, Tipo: tipo},
+		{Rel: relMiniatura, Href: imagen + "?w=240", Tipo: tipo},
+	}
+}
+
+// Tipo MIME de una imagen por su extensión, el mismo que manda
+// servirImagen (http.ServeContent)
+func tipoImagen(archivo string) string {
+	tipo := mime.TypeByExtension(strings.ToLower(path.Ext(archivo)))
+	if tipo == "" {
+		return "image/jpeg"
+	}
+	return tipo
+}
+
+// Capítulos de una serie con su enlace de descarga .cbz
+func OPDSSerieHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		mangaID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		manga, err := repository.GetMangaByID(db, mangaID)
+		if err != nil || (!manga.Disponible && !puedeEditar(db, r)) {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		capitulos, err := repository.GetCapitulosByManga(db, manga.ID)
+		if err != nil {
+			http.Error(w, "Error obteniendo capítulos", http.StatusInternalServerError)
+			return
+		}
+
+		primeras, err := repository.GetPrimerasPaginas(db, manga.ID)
+		if err != nil {
+			http.Error(w, "Error obteniendo capítulos", http.StatusInternalServerError)
+			return
+		}
+
+		feed := nuevoFeedOPDS(r, fmt.Sprintf("series:%d", manga.ID), manga.Titulo, tipoAdquisicionOPDS)
+		feed.Enlaces = append(feed.Enlaces, enlaceAtom{Rel: "up", Href: "/opds/series", Tipo: tipoAdquisicionOPDS})
+
+		for _, c := range capitulos {
+			if c.Faltante {
+				continue
+			}
+
+			entrada := entradaManga(manga)
+			entrada.ID = fmt.Sprintf("urn:inkzen:manga:%d:capitulo:%d", manga.ID, c.Numero)
+			entrada.Titulo = fmt.Sprintf("Capítulo %d", c.Numero)
+			if c.Titulo != "" {
+				entrada.Titulo += ": " + c.Titulo
+			}
+			entrada.Actualizado = fechaAtom(c.FechaPublicacion)
+			entrada.Contenido = nil
+			entrada.Emitido = c.FechaPublicacion.Format("2006-01-02")
+
+			if primera, ok := primeras[c.Numero]; ok {
+				entrada.Enlaces = enlacesImagen(primera)
+			}
+
+			entrada.Enlaces = append(entrada.Enlaces, enlaceAtom{
+				Rel:  relAdquisicion,
+				Href: fmt.Sprintf("/opds/series/%d/capitulos/%d/cbz", manga.ID, c.Numero),
+				Tipo: "application/vnd.comicbook+zip",
+			})
+
+			// El tipo de pse:stream es el de la primera página
+			if primera, ok := primeras[c.Numero]; ok && c.PaginasTot > 0 {
+				entrada.Enlaces = append(entrada.Enlaces, enlaceAtom{
+					Rel:     relStreamPSE,
+					Href:    fmt.Sprintf("/opds/series/%d/capitulos/%d/paginas/{pageNumber}?w={maxWidth}", manga.ID, c.Numero),
+					Tipo:    tipoImagen(primera),
+					Paginas: c.PaginasTot,
+				})
+			}
+
+			feed.Entradas = append(feed.Entradas, entrada)
+		}
+
+		escribirFeedOPDS(w, tipoAdquisicionOPDS, feed)
+	}
+}
+
+// Página suelta para pse:stream. El índice empieza en 0 y ?w= limita el
+// ancho. La petición avanza la página actual, pero el capítulo solo se
+// marca como leído al pedir la última justo después de la anterior (ver
+// registrarPaginaPSE)
+func OPDSPaginaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		manga, ok := mangaDescargable(db, w, r)
+		if !ok {
+			return
+		}
+
+		numero, _ := strconv.Atoi(chi.URLParam(r, "numero"))
+
+		capitulo, err := repository.GetCapitulo(db, manga.ID, numero)
+		if err != nil || capitulo.Faltante {
+			http.Error(w, "Capítulo no encontrado", http.StatusNotFound)
+			return
+		}
+
+		paginas, err := repository.GetPaginasByCapitulo(db, capitulo.ID)
+		if err != nil {
+			http.Error(w, "Error obteniendo páginas", http.StatusInternalServerError)
+			return
+		}
+
+		indice, err := strconv.Atoi(chi.URLParam(r, "indice"))
+		if err != nil || indice < 0 || indice >= len(paginas) {
+			http.Error(w, "Página no encontrada", http.StatusNotFound)
+			return
+		}
+
+		ancho, _ := strconv.Atoi(r.URL.Query().Get("w"))
+
+		ruta, hash, err := services.VarianteImagen(paginas[indice].Archivo, ancho)
+		if errors.Is(err, services.ErrImagenNoEncontrada) {
+			http.Error(w, "Página no encontrada", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error procesando imagen", http.StatusInternalServerError)
+			return
+		}
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err == nil {
+			registrarPaginaPSE(db, usuarioID, capitulo, indice, len(paginas), r.UserAgent())
+		}
+
+		// La URL no cambia con el contenido: se revalida siempre
+		servirImagen(w, r, ruta, hash, ancho, "private, no-cache")
+	}
+}
+
+// Los clientes PSE piden páginas por adelantado, así que una petición no
+// significa que se haya leído. Solo se avanza la página actual; el
+// capítulo cuenta como leído si se llega a la última página en orden
+// (la anterior era la última registrada) y va al historial al pedir
+// la primera
+func registrarPaginaPSE(db *sql.DB, usuarioID int, capitulo models.Capitulo, indice int, total int, agente string) {
+	terminado := terminaCapitulo(db, usuarioID, capitulo, indice+1, total)
+
+	repository.GuardarProgresoPagina(db, usuarioID, capitulo.MangaID, capitulo.Numero, indice+1, terminado)
+
+	if indice == 0 {
+		repository.RegistrarHistorial(db, models.EntradaHistorial{
+			UsuarioID: usuarioID,
+			MangaID:   capitulo.MangaID,
+			Capitulo:  capitulo.Numero,
+			Agente:    agente,
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+	"github.com/Graynie/InkZen/internal/services"
+)
+
+// Nivel de acceso de una operación
+const (
+	accesoLibre  = ""
+	accesoSesion = "sesion"
+	accesoEditor = "editor"
+	accesoAdmin  = "admin"
+)
+
+// Operación JSON documentada en la especificación. Cuerpo y Respuesta son
+// valores de ejemplo del tipo que se envía y se recibe: de ellos se saca
+// el esquema. Respuesta nil significa sin contenido y TextoPlano que la
+// respuesta es un mensaje de texto
+type operacionAPI struct {
+	Metodo     string
+	Ruta       string
+	Etiqueta   string
+	Resumen    string
+	Acceso     string
+	Parametros []parametroAPI
+	Cuerpo     interface{}
+	Estado     int
+	Respuesta  interface{}
+	TextoPlano bool
+	// La respuesta es una ListaAPI con Respuesta como elemento
+	Lista bool
+}
+
+// Parámetro de query
+type parametroAPI struct {
+	Nombre      string
+	Tipo        string
+	Descripcion string
+	Valores     []string
+}
+
+var parametrosPaginacion = []parametroAPI{
+	{Nombre: "pagina", Tipo: "integer", Descripcion: "Página, desde 1"},
+	{Nombre: "por_pagina", Tipo: "integer", Descripcion: "Elementos por página (24 por defecto, máximo 100)"},
+}
+
+var parametrosCatalogo = append([]parametroAPI{
+	{Nombre: "q", Tipo: "string", Descripcion: "Búsqueda en título, autor, género, editorial, descripción y capítulos"},
+	{Nombre: "genero", Tipo: "string"},
+	{Nombre: "idioma", Tipo: "string"},
+	{Nombre: "editorial", Tipo: "string"},
+	{Nombre: "disponible", Tipo: "string", Descripcion: "Solo admin y editores", Valores: []string{"todos", "no"}},
+	{Nombre: "orden", Tipo: "string", Valores: []string{
+		repository.OrdenRelevancia, repository.OrdenTitulo, repository.OrdenRecientes,
+		repository.OrdenActualizados, repository.OrdenPopulares,
+	}},
+}, parametrosPaginacion...)
+
+// Todas las rutas JSON de NewRouter. openapi_test.go comprueba que no
+// falte ninguna
+var operacionesAPI = []operacionAPI{
+	// API v1
+	{Metodo: "POST", Ruta: "/api/v1/sesion", Etiqueta: "v1 Usuarios", Resumen: "Inicia sesión y devuelve un token Bearer",
+		Cuerpo: CredencialesAPI{}, Respuesta: SesionAPI{}},
+	{Metodo: "POST", Ruta: "/api/v1/usuarios", Etiqueta: "v1 Usuarios", Resumen: "Registra un usuario lector",
+		Cuerpo: RegistroAPI{}, Estado: http.StatusCreated, Respuesta: UsuarioAPI{}},
+	{Metodo: "GET", Ruta: "/api/v1/usuarios/yo", Etiqueta: "v1 Usuarios", Resumen: "Usuario de la sesión",
+		Acceso: accesoSesion, Respuesta: UsuarioAPI{}},
+	{Metodo: "GET", Ruta: "/api/v1/usuarios", Etiqueta: "v1 Usuarios", Resumen: "Lista los usuarios",
+		Acceso: accesoAdmin, Parametros: parametrosPaginacion, Respuesta: UsuarioAPI{}, Lista: true},
+	{Metodo: "PUT", Ruta: "/api/v1/usuarios/{id}/rol", Etiqueta: "v1 Usuarios", Resumen: "Cambia el rol de un usuario",
+		Acceso: accesoAdmin, Cuerpo: RolAPI{}, Respuesta: UsuarioAPI{}},
+
+	{Metodo: "GET", Ruta: "/api/v1/mangas", Etiqueta: "v1 Mangas", Resumen: "Catálogo filtrado y paginado",
+		Parametros: parametrosCatalogo, Respuesta: MangaAPI{}, Lista: true},
+	{Metodo: "POST", Ruta: "/api/v1/mangas", Etiqueta: "v1 Mangas", Resumen: "Crea un manga",
+		Acceso: accesoEditor, Cuerpo: MangaAPI{}, Estado: http.StatusCreated, Respuesta: MangaAPI{}},
+	{Metodo: "GET", Ruta: "/api/v1/mangas/{id}", Etiqueta: "v1 Mangas", Resumen: "Un manga",
+		Respuesta: MangaAPI{}},
+	{Metodo: "PUT", Ruta: "/api/v1/mangas/{id}", Etiqueta: "v1 Mangas", Resumen: "Edita un manga (no la portada ni los capítulos)",
+		Acceso: accesoEditor, Cuerpo: MangaAPI{}, Respuesta: MangaAPI{}},
+	{Metodo: "DELETE", Ruta: "/api/v1/mangas/{id}", Etiqueta: "v1 Mangas", Resumen: "Borra un manga con sus capítulos y archivos",
+		Acceso: accesoEditor, Estado: http.StatusNoContent},
+	{Metodo: "GET", Ruta: "/api/v1/mangas/{id}/capitulos", Etiqueta: "v1 Mangas", Resumen: "Capítulos de un manga",
+		Parametros: parametrosPaginacion, Respuesta: CapituloAPI{}, Lista: true},
+	{Metodo: "GET", Ruta: "/api/v1/mangas/{id}/capitulos/{numero}", Etiqueta: "v1 Mangas", Resumen: "Un capítulo",
+		Respuesta: CapituloAPI{}},
+	{Metodo: "GET", Ruta: "/api/v1/mangas/{id}/capitulos/{numero}/paginas", Etiqueta: "v1 Mangas", Resumen: "Páginas de un capítulo",
+		Parametros: parametrosPaginacion, Respuesta: PaginaAPI{}, Lista: true},
+
+	{Metodo: "GET", Ruta: "/api/v1/lecturas", Etiqueta: "v1 Lecturas", Resumen: "Biblioteca del usuario",
+		Acceso: accesoSesion, Respuesta: EntradaBibliotecaAPI{}, Lista: true,
+		Parametros: append([]parametroAPI{{Nombre: "estado", Tipo: "string", Valores: models.EstadosLectura}}, parametrosPaginacion...)},
+	{Metodo: "GET", Ruta: "/api/v1/lecturas/{manga_id}", Etiqueta: "v1 Lecturas", Resumen: "Lectura de un manga",
+		Acceso: accesoSesion, Respuesta: LecturaAPI{}},
+	{Metodo: "PUT", Ruta: "/api/v1/lecturas/{manga_id}", Etiqueta: "v1 Lecturas", Resumen: "Estado y puntuación; añade el manga a la biblioteca",
+		Acceso: accesoSesion, Cuerpo: EstadoLecturaAPI{}, Respuesta: LecturaAPI{}},
+	{Metodo: "PUT", Ruta: "/api/v1/lecturas/{manga_id}/progreso", Etiqueta: "v1 Lecturas", Resumen: "Guarda la página alcanzada",
+		Acceso: accesoSesion, Cuerpo: ProgresoAPI{}, Respuesta: LecturaAPI{}},
+
+	// Rutas JSON anteriores a /api/v1
+	{Metodo: "POST", Ruta: "/usuarios", Etiqueta: "Usuarios", Resumen: "Registra un usuario",
+		Cuerpo: models.Usuario{}, Estado: http.StatusCreated, TextoPlano: true},
+	{Metodo: "GET", Ruta: "/usuarios", Etiqueta: "Usuarios", Resumen: "Lista los usuarios",
+		Acceso: accesoAdmin, Respuesta: []models.Usuario{}},
+	{Metodo: "PUT", Ruta: "/usuarios/{id}/rol", Etiqueta: "Usuarios", Resumen: "Cambia el rol de un usuario",
+		Acceso: accesoAdmin, Cuerpo: struct{ Rol string }{}, TextoPlano: true},
+
+	{Metodo: "POST", Ruta: "/lecturas", Etiqueta: "Lecturas", Resumen: "Guarda el capítulo actual; crea la lectura si no existe",
+		Acceso: accesoSesion, Cuerpo: CapituloLectura{}, Estado: http.StatusCreated, Respuesta: models.Lectura{}},
+	{Metodo: "PUT", Ruta: "/lecturas", Etiqueta: "Lecturas", Resumen: "Guarda el capítulo actual; crea la lectura si no existe",
+		Acceso: accesoSesion, Cuerpo: CapituloLectura{}, Respuesta: models.Lectura{}},
+	{Metodo: "POST", Ruta: "/lecturas/progreso", Etiqueta: "Lecturas", Resumen: "Página alcanzada en el lector",
+		Acceso: accesoSesion, Cuerpo: ProgresoPagina{}, Respuesta: models.Lectura{}},
+
+	{Metodo: "GET", Ruta: "/historial", Etiqueta: "Historial", Resumen: "Historial de lectura",
+		Acceso: accesoSesion, Respuesta: PaginaHistorial{},
+		Parametros: append([]parametroAPI{
+			{Nombre: "manga", Tipo: "integer"},
+			{Nombre: "desde", Tipo: "string", Descripcion: "AAAA-MM-DD, incluido"},
+			{Nombre: "hasta", Tipo: "string", Descripcion: "AAAA-MM-DD, incluido"},
+		}, parametrosPaginacion...)},
+	{Metodo: "DELETE", Ruta: "/historial/{id}", Etiqueta: "Historial", Resumen: "Borra una entrada del historial",
+		Acceso: accesoSesion, Estado: http.StatusNoContent},
+	{Metodo: "DELETE", Ruta: "/historial", Etiqueta: "Historial", Resumen: "Borra todo el historial",
+		Acceso: accesoSesion, Estado: http.StatusNoContent},
+
+	{Metodo: "GET", Ruta: "/marcadores", Etiqueta: "Marcadores", Resumen: "Marcadores del usuario",
+		Acceso: accesoSesion, Respuesta: []models.Marcador{},
+		Parametros: []parametroAPI{{Nombre: "manga", Tipo: "integer", Descripcion: "Solo los de este manga"}}},
+	{Metodo: "POST", Ruta: "/marcadores", Etiqueta: "Marcadores", Resumen: "Crea un marcador o cambia su nota",
+		Acceso: accesoSesion, Cuerpo: models.Marcador{}, Estado: http.StatusCreated, Respuesta: models.Marcador{}},
+	{Metodo: "PUT", Ruta: "/marcadores/{id}", Etiqueta: "Marcadores", Resumen: "Cambia la nota de un marcador",
+		Acceso: accesoSesion, Cuerpo: struct{ Nota string }{}, Respuesta: models.Marcador{}},
+	{Metodo: "DELETE", Ruta: "/marcadores/{id}", Etiqueta: "Marcadores", Resumen: "Borra un marcador",
+		Acceso: accesoSesion, Estado: http.StatusNoContent},
+
+	{Metodo: "GET", Ruta: "/mangas", Etiqueta: "Mangas", Resumen: "Catálogo filtrado y paginado",
+		Parametros: parametrosCatalogo, Respuesta: PaginaCatalogo{}},
+	{Metodo: "PUT", Ruta: "/mangas/{id}", Etiqueta: "Mangas", Resumen: "Edita un manga",
+		Acceso: accesoEditor, Cuerpo: models.Manga{}, Respuesta: models.Manga{}},
+	{Metodo: "DELETE", Ruta: "/mangas/{id}", Etiqueta: "Mangas", Resumen: "Borra un manga",
+		Acceso: accesoEditor, Estado: http.StatusNoContent},
+
+	{Metodo: "POST", Ruta: "/admin/escanear", Etiqueta: "Administración", Resumen: "Escanea la biblioteca ahora",
+		Acceso: accesoAdmin, Respuesta: services.ResultadoEscaneo{}},
+}
+
+var parametroRuta = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Documento OpenAPI 3 con las operaciones de operacionesAPI. Los esquemas
+// salen de los structs por reflexión, con los nombres de sus tags json
+func EspecificacionOpenAPI() map[string]interface{} {
+	esquemas := map[string]interface{}{}
+	rutas := map[string]interface{}{}
+
+	esquemas["ErrorAPI"] = esquemaDe(reflect.TypeOf(ErrorAPI{}), esquemas)
+
+	for _, op := range operacionesAPI {
+		ruta, ok := rutas[op.Ruta].(map[string]interface{})
+		if !ok {
+			ruta = map[string]interface{}{}
+			rutas[op.Ruta] = ruta
+		}
+		ruta[strings.ToLower(op.Metodo)] = operacionOpenAPI(op, esquemas)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "InkZen",
+			"version":     "1",
+			"description": "API JSON de InkZen. Las rutas nuevas van bajo /api/v1; el resto se mantiene por compatibilidad.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": "/"}},
+		"paths":   rutas,
+		"components": map[string]interface{}{
+			"schemas": esquemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"cookie": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "auth_token"},
+			},
+		},
+	}
+}
+
+func operacionOpenAPI(op operacionAPI, esquemas map[string]interface{}) map[string]interface{} {
+	resultado := map[string]interface{}{
+		"tags":        []string{op.Etiqueta},
+		"summary":     op.Resumen,
+		"operationId": op.Metodo + " " + op.Ruta,
+	}
+
+	var parametros []interface{}
+	for _, m := range parametroRuta.FindAllStringSubmatch(op.Ruta, -1) {
+		parametros = append(parametros, map[string]interface{}{
+			"name": m[1], "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "integer"},
+		})
+	}
+	for _, p := range op.Parametros {
+		esquema := map[string]interface{}{"type": p.Tipo}
+		if len(p.Valores) > 0 {
+			esquema["enum"] = p.Valores
+		}
+		parametro := map[string]interface{}{"name": p.Nombre, "in": "query", "schema": esquema}
+		if p.Descripcion != "" {
+			parametro["description"] = p.Descripcion
+		}
+		parametros = append(parametros, parametro)
+	}
+	if len(parametros) > 0 {
+		resultado["parameters"] = parametros
+	}
+
+	if op.Cuerpo != nil {
+		resultado["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": esquemaDe(reflect.TypeOf(op.Cuerpo), esquemas)},
+			},
+		}
+	}
+
+	if op.Acceso != accesoLibre {
+		resultado["security"] = []interface{}{
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"cookie": []string{}},
+		}
+		switch op.Acceso {
+		case accesoEditor:
+			resultado["description"] = "Requiere rol admin o editor."
+		case accesoAdmin:
+			resultado["description"] = "Requiere rol admin."
+		}
+	}
+
+	estado := op.Estado
+	if estado == 0 {
+		estado = http.StatusOK
+	}
+
+	respuesta := map[string]interface{}{"description": http.StatusText(estado)}
+
+	switch {
+	case op.TextoPlano:
+		respuesta["content"] = map[string]interface{}{
+			"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		}
+	case op.Respuesta != nil:
+		esquema := esquemaDe(reflect.TypeOf(op.Respuesta), esquemas)
+		if op.Lista {
+			esquema = map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"datos":      map[string]interface{}{"type": "array", "items": esquema},
+					"paginacion": esquemaDe(reflect.TypeOf(PaginacionAPI{}), esquemas),
+				},
+			}
+		}
+		respuesta["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": esquema},
+		}
+	}
+
+	respuestas := map[string]interface{}{strconv.Itoa(estado): respuesta}
+
+	// /api/v1 responde los errores con ErrorAPI; las rutas antiguas, con texto
+	if strings.HasPrefix(op.Ruta, "/api/v1/") {
+		respuestas["default"] = map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/ErrorAPI"}},
+			},
+		}
+	} else {
+		respuestas["default"] = map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			},
+		}
+	}
+
+	resultado["responses"] = respuestas
+	return resultado
+}
+
+var tipoTiempo = reflect.TypeOf(time.Time{})
+
+// Esquema JSON de t como lo codifica encoding/json. Los structs con nombre
+// se registran en esquemas y se referencian con $ref
+func esquemaDe(t reflect.Type, esquemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == tipoTiempo:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := esquemas[t.Name()]; !ok {
+			// Se reserva antes de recorrer los campos por si el tipo es recursivo
+			esquemas[t.Name()] = nil
+			esquemas[t.Name()] = esquemaStruct(t, esquemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return esquemaStruct(t, esquemas)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": esquemaDe(t.Elem(), esquemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": esquemaDe(t.Elem(), esquemas)}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+
+	// interface{}: cualquier valor
+	return map[string]interface{}{}
+}
+
+func esquemaStruct(t reflect.Type, esquemas map[string]interface{}) map[string]interface{} {
+	propiedades := map[string]interface{}{}
+
+	var recorrer func(t reflect.Type)
+	recorrer = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			campo := t.Field(i)
+
+			tag := campo.Tag.Get("json")
+			nombre, _, _ := strings.Cut(tag, ",")
+			if nombre == "-" {
+				continue
+			}
+
+			// Los structs embebidos sin tag aportan sus campos, igual
+			// que en encoding/json
+			if campo.Anonymous && nombre == "" && campo.Type.Kind() == reflect.Struct {
+				recorrer(campo.Type)
+				continue
+			}
+			if !campo.IsExported() {
+				continue
+			}
+
+			if nombre == "" {
+				nombre = campo.Name
+			}
+			propiedades[nombre] = esquemaDe(campo.Type, esquemas)
+		}
+	}
+	recorrer(t)
+
+	return map[string]interface{}{"type": "object", "properties": propiedades}
+}
+
+// Especificación en JSON
+func OpenAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(EspecificacionOpenAPI())
+	}
+}
+
+// Visor interactivo de la especificación
+func APIDocsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		tmpl, err := parsePlantilla("web/templates/api_docs.html")
+		if err != nil {
+			http.Error(w, "Error cargando documentación", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl.Execute(w, nil)
+	}
+}
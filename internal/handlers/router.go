@@ -3,12 +3,18 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"sort"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"html/template"
 
@@ -18,22 +24,63 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-func NewRouter(db *sql.DB) http.Handler {
+func NewRouter(db *sql.DB, escaner *services.Escaner) http.Handler {
 	r := chi.NewRouter()
 
 	userService := services.NewUsuarioService()
 
+	soloAdmin := RequireRol(db, models.RolAdmin)
+	soloEditores := RequireRol(db, models.RolAdmin, models.RolEditor)
+	opds := r.With(RequireBasicOJWT(db))
+
 	r.Get("/", HomeHandler)
 	r.Post("/usuarios", CreateUserHandler(db, userService))
-	r.With(JWTMiddleware).Get("/usuarios", GetUsersHandler(db))
+	r.With(soloAdmin).Get("/usuarios", GetUsersHandler(db))
+	r.With(soloAdmin).Put("/usuarios/{id}/rol", UpdateRolHandler(db))
 	r.Post("/lecturas", CreateLecturaHandler(db))
 	r.Put("/lecturas", UpdateLecturaHandler(db))
+	r.Post("/lecturas/progreso", ProgresoLecturaHandler(db))
+	r.Post("/lecturas/estado", EstadoLecturaHandler(db))
+	r.Post("/lecturas/capitulos", CapitulosLeidosHandler(db))
 	r.Get("/mis-mangas", GetLecturasHandler(db))
+	r.Get("/historial-web", HistorialWebHandler(db))
+	r.Post("/historial-web/delete", DeleteEntradaHistorialWebHandler(db))
+	r.Post("/historial-web/clear", ClearHistorialWebHandler(db))
+	r.Get("/historial", GetHistorialHandler(db))
+	r.Delete("/historial/{id}", DeleteEntradaHistorialHandler(db))
+	r.Delete("/historial", ClearHistorialHandler(db))
+	r.Get("/marcadores", GetMarcadoresHandler(db))
+	r.Post("/marcadores", CreateMarcadorHandler(db))
+	r.Put("/marcadores/{id}", UpdateMarcadorHandler(db))
+	r.Delete("/marcadores/{id}", DeleteMarcadorHandler(db))
+	r.Post("/marcadores-web", CreateMarcadorWebHandler(db))
+	r.Post("/marcadores-web/delete", DeleteMarcadorWebHandler(db))
 	r.Get("/mangas-web", WebMangasHandler(db))
-	r.Get("/mangas/new", CreateMangaFormHandler())
-	r.Post("/mangas-web", CreateMangaWebHandler(db))
+	r.Get("/mangas", ListMangasHandler(db))
+	r.With(soloEditores).Get("/mangas/new", CreateMangaFormHandler())
+	r.With(soloEditores).Post("/mangas-web", CreateMangaWebHandler(db))
+	r.With(soloEditores).Get("/mangas/edit", EditMangaFormHandler(db))
+	r.With(soloEditores).Post("/mangas-web/edit", UpdateMangaWebHandler(db))
+	r.With(soloEditores).Post("/mangas-web/delete", DeleteMangaWebHandler(db))
+	r.With(soloEditores).Put("/mangas/{id}", UpdateMangaHandler(db))
+	r.With(soloEditores).Delete("/mangas/{id}", DeleteMangaHandler(db))
+	r.With(soloEditores).Get("/capitulos/new", UploadCapituloFormHandler(db))
+	r.With(soloEditores).Post("/capitulos-web", UploadCapituloHandler(db))
+	r.Get("/mangas/{id}/capitulos/{numero}/cbz", DescargarCapituloHandler(db))
+	r.Get("/mangas/{id}/zip", DescargarSerieHandler(db))
+	r.Get("/mangas/{id}/epub", DescargarEPUBHandler(db))
 	r.Get("/manga", ViewMangaHandler(db))
 	r.Get("/capitulo", ViewCapituloHandler(db))
+	r.Post("/preferencias/direccion", DireccionLecturaHandler(db))
+	r.Get("/imagenes/{hash}/*", ImagenHandler())
+	opds.Get("/opds", OPDSRaizHandler())
+	opds.Get("/opds/opensearch.xml", OPDSOpenSearchHandler())
+	opds.Get("/opds/generos", OPDSGenerosHandler(db))
+	opds.Get("/opds/idiomas", OPDSIdiomasHandler(db))
+	opds.Get("/opds/series", OPDSSeriesHandler(db))
+	opds.Get("/opds/series/{id}", OPDSSerieHandler(db))
+	opds.Get("/opds/series/{id}/capitulos/{numero}/cbz", DescargarCapituloHandler(db))
+	opds.Get("/opds/series/{id}/capitulos/{numero}/paginas/{indice}", OPDSPaginaHandler(db))
 	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
 	r.Get("/register", RegisterFormHandler())
 	r.Post("/register", RegisterHandler(db))
@@ -43,6 +90,12 @@ func NewRouter(db *sql.DB) http.Handler {
 
 	r.Get("/logout", LogoutHandler())
 
+	r.With(soloAdmin).Post("/admin/escanear", EscanearBibliotecaHandler(escaner))
+
+	r.Mount("/api/v1", APIRouter(db))
+	r.Get("/api/docs", APIDocsHandler())
+	r.Get("/api/docs/openapi.json", OpenAPIHandler())
+
 	return r
 }
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
@@ -87,7 +140,7 @@ func CreateUserHandler(db *sql.DB, userService *services.UsuarioService) http.Ha
 func GetUsersHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		rows, err := db.Query("SELECT id, nombre, email FROM usuarios")
+		rows, err := db.Query("SELECT id, nombre, email, rol FROM usuarios")
 		if err != nil {
 			http.Error(w, "Error consultando usuarios", http.StatusInternalServerError)
 			return
@@ -98,7 +151,7 @@ func GetUsersHandler(db *sql.DB) http.HandlerFunc {
 
 		for rows.Next() {
 			var u models.Usuario
-			err := rows.Scan(&u.ID, &u.Nombre, &u.Email)
+			err := rows.Scan(&u.ID, &u.Nombre, &u.Email, &u.Rol)
 			if err != nil {
 				http.Error(w, "Error leyendo usuarios", http.StatusInternalServerError)
 				return
@@ -110,131 +163,234 @@ func GetUsersHandler(db *sql.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(usuarios)
 	}
 }
-func CreateLecturaHandler(db *sql.DB) http.HandlerFunc {
+func UpdateRolHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		var lectura models.Lectura
+		usuarioID, _ := strconv.Atoi(chi.URLParam(r, "id"))
 
-		err := json.NewDecoder(r.Body).Decode(&lectura)
+		var body struct {
+			Rol string
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&body)
 		if err != nil {
 			http.Error(w, "JSON inválido", http.StatusBadRequest)
 			return
 		}
 
-		err = repository.CrearLectura(db, lectura)
+		if body.Rol != models.RolAdmin && body.Rol != models.RolEditor && body.Rol != models.RolLector {
+			http.Error(w, "Rol inválido", http.StatusBadRequest)
+			return
+		}
+
+		err = repository.ActualizarRol(db, usuarioID, body.Rol)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+			return
+		}
 		if err != nil {
-			http.Error(w, "Error creando lectura", http.StatusInternalServerError)
+			http.Error(w, "Error actualizando rol", http.StatusInternalServerError)
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
-		w.Write([]byte("Lectura creada"))
+		w.Write([]byte("Rol actualizado"))
 	}
 }
+
+// Cuerpo de POST y PUT /lecturas: {"MangaID": 1, "CapituloActual": 3}.
+// El usuario sale siempre del token, nunca del cuerpo
+type CapituloLectura struct {
+	MangaID        int
+	CapituloActual int
+}
+
+func CreateLecturaHandler(db *sql.DB) http.HandlerFunc {
+	return guardarCapituloLectura(db, http.StatusCreated)
+}
+
 func UpdateLecturaHandler(db *sql.DB) http.HandlerFunc {
+	return guardarCapituloLectura(db, http.StatusOK)
+}
+
+// Los dos crean la lectura si no existe y si existe cambian su capítulo
+func guardarCapituloLectura(db *sql.DB, estado int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		var lectura models.Lectura
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
+
+		var body CapituloLectura
 
-		err := json.NewDecoder(r.Body).Decode(&lectura)
+		err = json.NewDecoder(r.Body).Decode(&body)
 		if err != nil {
 			http.Error(w, "JSON inválido", http.StatusBadRequest)
 			return
 		}
 
-		err = repository.ActualizarCapitulo(db, lectura.UsuarioID, lectura.MangaID, lectura.CapituloActual)
+		if body.CapituloActual < 0 {
+			http.Error(w, "Capítulo inválido", http.StatusBadRequest)
+			return
+		}
+
+		manga, err := repository.GetMangaByID(db, body.MangaID)
+		if err != nil || (!manga.Disponible && !puedeEditar(db, r)) {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		err = repository.GuardarCapituloActual(db, usuarioID, manga.ID, body.CapituloActual)
+		if err != nil {
+			http.Error(w, "Error guardando lectura", http.StatusInternalServerError)
+			return
+		}
+
+		lectura, err := repository.GetLectura(db, usuarioID, manga.ID)
 		if err != nil {
-			http.Error(w, "Error actualizando capítulo", http.StatusInternalServerError)
+			http.Error(w, "Error obteniendo lectura", http.StatusInternalServerError)
 			return
 		}
 
-		w.Write([]byte("Capítulo actualizado"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(estado)
+		json.NewEncoder(w).Encode(lectura)
 	}
 }
-func GetLecturasHandler(db *sql.DB) http.HandlerFunc {
+
+// Página alcanzada por el lector: {"MangaID": 1, "Capitulo": 3, "Pagina": 12}
+type ProgresoPagina struct {
+	MangaID  int
+	Capitulo int
+	Pagina   int
+}
+
+// Un capítulo cuenta como leído al llegar a su última página desde la
+// anterior, igual en el lector web, la API y OPDS-PSE: abrir o enviar
+// directamente la última página no lo marca
+func terminaCapitulo(db *sql.DB, usuarioID int, capitulo models.Capitulo, pagina int, total int) bool {
+	if pagina != total {
+		return false
+	}
+	if pagina == 1 {
+		return true
+	}
+
+	lectura, err := repository.GetLectura(db, usuarioID, capitulo.MangaID)
+	return err == nil && lectura.CapituloActual == capitulo.Numero && lectura.PaginaActual == pagina-1
+}
+
+// Lo llama el lector al pasar página o hacer scroll
+func ProgresoLecturaHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		usuarioIDStr := r.URL.Query().Get("usuario_id")
-		if usuarioIDStr == "" {
-			http.Error(w, "usuario_id requerido", http.StatusBadRequest)
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
 			return
 		}
 
-		usuarioID, _ := strconv.Atoi(usuarioIDStr)
+		var progreso ProgresoPagina
 
-		lecturas, err := repository.ObtenerLecturasUsuario(db, usuarioID)
+		err = json.NewDecoder(r.Body).Decode(&progreso)
 		if err != nil {
-			http.Error(w, "Error obteniendo lecturas", http.StatusInternalServerError)
+			http.Error(w, "JSON inválido", http.StatusBadRequest)
+			return
+		}
+
+		capitulo, err := repository.GetCapitulo(db, progreso.MangaID, progreso.Capitulo)
+		if err != nil {
+			http.Error(w, "Capítulo no encontrado", http.StatusNotFound)
+			return
+		}
+
+		if progreso.Pagina < 1 || progreso.Pagina > capitulo.PaginasTot {
+			http.Error(w, "Página inválida", http.StatusBadRequest)
+			return
+		}
+
+		terminado := terminaCapitulo(db, usuarioID, capitulo, progreso.Pagina, capitulo.PaginasTot)
+
+		err = repository.GuardarProgresoPagina(db, usuarioID, capitulo.MangaID, capitulo.Numero, progreso.Pagina, terminado)
+		if err != nil {
+			http.Error(w, "Error guardando progreso", http.StatusInternalServerError)
+			return
+		}
+
+		lectura, err := repository.GetLectura(db, usuarioID, capitulo.MangaID)
+		if err != nil {
+			http.Error(w, "Error obteniendo lectura", http.StatusInternalServerError)
 			return
 		}
 
-		json.NewEncoder(w).Encode(lecturas)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lectura)
 	}
 }
 
-func WebMangasHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// Estantería de la biblioteca del usuario
+type Estanteria struct {
+	Estado   string
+	Nombre   string
+	Entradas []models.EntradaBiblioteca
+}
 
-		// 🔹 Obtener usuario desde cookie
-		var nombreUsuario string
+type OpcionEstado struct {
+	Valor  string
+	Nombre string
+}
 
-		usuarioID, errUser := getUserIDFromRequest(r)
-		if errUser == nil && usuarioID > 0 {
+func opcionesEstado() []OpcionEstado {
+	var opciones []OpcionEstado
+	for _, estado := range models.EstadosLectura {
+		opciones = append(opciones, OpcionEstado{estado, models.NombreEstado(estado)})
+	}
+	return opciones
+}
 
-			err := db.QueryRow(
-				"SELECT nombre FROM usuarios WHERE id = ?",
-				usuarioID,
-			).Scan(&nombreUsuario)
+// Biblioteca del usuario agrupada por estado (?estado= muestra solo uno)
+func GetLecturasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 
-			if err != nil {
-				nombreUsuario = ""
-			}
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
 		}
 
-		// 🔹 Obtener búsqueda
-		query := r.URL.Query().Get("q")
-
-		rows, err := db.Query(`
-			SELECT id, titulo, autor, genero, idioma, editorial, descripcion, capitulos_tot, disponible
-			FROM mangas
-			WHERE disponible = 1 AND titulo LIKE ?
-		`, "%"+query+"%")
-
+		entradas, err := repository.ObtenerBiblioteca(db, usuarioID)
 		if err != nil {
-			http.Error(w, "Error obteniendo mangas", http.StatusInternalServerError)
+			http.Error(w, "Error obteniendo lecturas", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
 
-		// 🔹 Declarar slice correctamente
-		var mangas []models.Manga
+		filtro := r.URL.Query().Get("estado")
 
-		for rows.Next() {
-			var m models.Manga
-			err := rows.Scan(
-				&m.ID,
-				&m.Titulo,
-				&m.Autor,
-				&m.Genero,
-				&m.Idioma,
-				&m.Editorial,
-				&m.Descripcion,
-				&m.CapitulosTot,
-				&m.Disponible,
-			)
-			if err != nil {
+		var estanterias []Estanteria
+		for _, estado := range models.EstadosLectura {
+			if filtro != "" && filtro != estado {
 				continue
 			}
-			mangas = append(mangas, m)
+
+			e := Estanteria{Estado: estado, Nombre: models.NombreEstado(estado)}
+			for _, entrada := range entradas {
+				if entrada.Estado == estado {
+					e.Entradas = append(e.Entradas, entrada)
+				}
+			}
+
+			estanterias = append(estanterias, e)
 		}
 
-		// 🔹 Enviar datos al template
 		data := map[string]interface{}{
-			"Mangas":  mangas,
-			"Usuario": nombreUsuario,
+			"Estanterias": estanterias,
+			"Estados":     opcionesEstado(),
+			"Filtro":      filtro,
+			"Total":       len(entradas),
 		}
 
-		tmpl, err := template.ParseFiles("web/templates/mangas.html")
+		tmpl, err := parsePlantilla("web/templates/biblioteca.html")
 		if err != nil {
 			http.Error(w, "Error cargando template", http.StatusInternalServerError)
 			return
@@ -244,244 +400,1688 @@ func WebMangasHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func CreateMangaFormHandler() http.HandlerFunc {
+// Cambia el estado y la puntuación de un manga en la biblioteca
+func EstadoLecturaHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		tmpl, err := template.ParseFiles("web/templates/create_manga.html")
+		usuarioID, err := getUserIDFromRequest(r)
 		if err != nil {
-			http.Error(w, "Error cargando formulario", http.StatusInternalServerError)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
-		tmpl.Execute(w, nil)
-	}
-}
-func CreateMangaWebHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
 
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Error procesando formulario", http.StatusBadRequest)
+		mangaID, _ := strconv.Atoi(r.FormValue("manga_id"))
+		if _, err := repository.GetMangaByID(db, mangaID); err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
 			return
 		}
 
-		disponible := false
-		if r.FormValue("disponible") == "true" {
-			disponible = true
+		estado := r.FormValue("estado")
+		if !models.EstadoValido(estado) {
+			http.Error(w, "Estado inválido", http.StatusBadRequest)
+			return
 		}
 
-		capitulosTot, _ := strconv.Atoi(r.FormValue("capitulos_tot"))
-
-		manga := models.Manga{
-			Titulo:       r.FormValue("titulo"),
-			Autor:        r.FormValue("autor"),
-			Genero:       r.FormValue("genero"),
-			Idioma:       r.FormValue("idioma"),
-			Editorial:    r.FormValue("editorial"),
-			Descripcion:  r.FormValue("descripcion"),
-			CapitulosTot: capitulosTot,
-			Disponible:   disponible,
+		puntuacion, _ := strconv.Atoi(r.FormValue("puntuacion"))
+		if puntuacion < 0 || puntuacion > models.MaxPuntuacion {
+			http.Error(w, "Puntuación inválida", http.StatusBadRequest)
+			return
 		}
 
-		err := repository.CreateManga(db, manga)
+		err = repository.ActualizarEstadoLectura(db, usuarioID, mangaID, estado, puntuacion)
 		if err != nil {
-			http.Error(w, "Error guardando manga", http.StatusInternalServerError)
+			http.Error(w, "Error guardando estado", http.StatusInternalServerError)
 			return
 		}
 
-		http.Redirect(w, r, "/mangas-web", http.StatusSeeOther)
+		http.Redirect(w, r, rutaLocal(r.FormValue("volver"), "/mis-mangas"), http.StatusSeeOther)
 	}
 }
-func ViewMangaHandler(db *sql.DB) http.HandlerFunc {
+
+// Página del historial con los metadatos de paginación
+type PaginaHistorial struct {
+	Entradas  []models.EntradaHistorial
+	Total     int
+	Pagina    int
+	PorPagina int
+	Paginas   int
+}
+
+// Lee manga, desde, hasta (AAAA-MM-DD, ambos incluidos), pagina y por_pagina
+func filtroHistorialDesdeQuery(r *http.Request) repository.FiltroHistorial {
+	q := r.URL.Query()
+
+	mangaID, _ := strconv.Atoi(q.Get("manga"))
+	pagina, _ := strconv.Atoi(q.Get("pagina"))
+	porPagina, _ := strconv.Atoi(q.Get("por_pagina"))
+
+	filtro := repository.FiltroHistorial{
+		MangaID:   mangaID,
+		Pagina:    pagina,
+		PorPagina: porPagina,
+	}
+
+	if desde, err := time.ParseInLocation("2006-01-02", q.Get("desde"), time.Local); err == nil {
+		filtro.Desde = desde
+	}
+	if hasta, err := time.ParseInLocation("2006-01-02", q.Get("hasta"), time.Local); err == nil {
+		filtro.Hasta = hasta.AddDate(0, 0, 1)
+	}
+
+	filtro.Normalizar()
+	return filtro
+}
+
+func listarHistorial(db *sql.DB, usuarioID int, filtro repository.FiltroHistorial) (PaginaHistorial, error) {
+	entradas, total, err := repository.ListarHistorial(db, usuarioID, filtro)
+	if err != nil {
+		return PaginaHistorial{}, err
+	}
+
+	return PaginaHistorial{
+		Entradas:  entradas,
+		Total:     total,
+		Pagina:    filtro.Pagina,
+		PorPagina: filtro.PorPagina,
+		Paginas:   (total + filtro.PorPagina - 1) / filtro.PorPagina,
+	}, nil
+}
+
+func HistorialWebHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		mangaIDStr := r.URL.Query().Get("id")
-		mangaID, _ := strconv.Atoi(mangaIDStr)
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
 
-		// Obtener manga
-		var mangas []models.Manga
-		var manga models.Manga
-		err := db.QueryRow(`
-			SELECT id, titulo, autor, genero, idioma, editorial, descripcion, capitulos_tot, disponible
-			FROM mangas
-			WHERE id = ?
-		`, mangaID).Scan(
-			&manga.ID,
-			&manga.Titulo,
-			&manga.Autor,
-			&manga.Genero,
-			&manga.Idioma,
-			&manga.Editorial,
-			&manga.Descripcion,
-			&manga.CapitulosTot,
-			&manga.Disponible,
-		)
+		pagina, err := listarHistorial(db, usuarioID, filtroHistorialDesdeQuery(r))
+		if err != nil {
+			http.Error(w, "Error obteniendo historial", http.StatusInternalServerError)
+			return
+		}
 
+		mangas, err := repository.MangasEnHistorial(db, usuarioID)
 		if err != nil {
-			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			http.Error(w, "Error obteniendo historial", http.StatusInternalServerError)
 			return
 		}
 
-		// Leer capítulos desde carpeta
-		path := fmt.Sprintf("web/static/uploads/%d/capitulos/", manga.ID)
-		files, _ := os.ReadDir(path)
+		q := r.URL.Query()
+		mangaID, _ := strconv.Atoi(q.Get("manga"))
 
-		type CapituloView struct {
-			Numero string
-			Leido  bool
-			Actual bool
+		data := map[string]interface{}{
+			"Pagina":  pagina,
+			"Mangas":  mangas,
+			"MangaID": mangaID,
+			"Desde":   q.Get("desde"),
+			"Hasta":   q.Get("hasta"),
+			"Volver":  r.URL.RequestURI(),
 		}
 
-		var capitulos []CapituloView
+		if pagina.Pagina > 1 {
+			data["URLAnterior"] = urlPagina(r, pagina.Pagina-1)
+		}
+		if pagina.Pagina < pagina.Paginas {
+			data["URLSiguiente"] = urlPagina(r, pagina.Pagina+1)
+		}
 
-		// Obtener progreso
-		usuarioID, errUser := getUserIDFromRequest(r)
+		tmpl, err := parsePlantilla("web/templates/historial.html")
+		if err != nil {
+			http.Error(w, "Error cargando template", http.StatusInternalServerError)
+			return
+		}
 
-		var nombreUsuario string
+		tmpl.Execute(w, data)
+	}
+}
 
-		if errUser == nil {
-			db.QueryRow("SELECT nombre FROM usuarios WHERE id = ?", usuarioID).Scan(&nombreUsuario)
+func DeleteEntradaHistorialWebHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
 		}
 
-		var capActual int
+		r.ParseForm()
 
-		errLectura := db.QueryRow(`
-			SELECT capitulo_actual
-			FROM lecturas
-			WHERE usuario_id = ? AND manga_id = ?
-		`, usuarioID, manga.ID).Scan(&capActual)
+		id, _ := strconv.Atoi(r.FormValue("id"))
 
-		if errLectura != nil {
-			capActual = 0
+		err = repository.BorrarEntradaHistorial(db, usuarioID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Entrada no encontrada", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error borrando historial", http.StatusInternalServerError)
+			return
 		}
-		for _, file := range files {
-			if file.IsDir() {
 
-				num, _ := strconv.Atoi(file.Name())
+		http.Redirect(w, r, rutaLocal(r.FormValue("volver"), "/historial-web"), http.StatusSeeOther)
+	}
+}
 
-				c := CapituloView{
-					Numero: file.Name(),
-				}
+func ClearHistorialWebHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 
-				if num < capActual {
-					c.Leido = true
-				}
-				if num == capActual {
-					c.Actual = true
-				}
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
 
-				capitulos = append(capitulos, c)
-			}
+		err = repository.BorrarHistorial(db, usuarioID)
+		if err != nil {
+			http.Error(w, "Error borrando historial", http.StatusInternalServerError)
+			return
 		}
 
-		sort.Slice(capitulos, func(i, j int) bool {
-			ci, _ := strconv.Atoi(capitulos[i].Numero)
-			cj, _ := strconv.Atoi(capitulos[j].Numero)
-			return ci < cj
-		})
+		http.Redirect(w, r, "/historial-web", http.StatusSeeOther)
+	}
+}
 
-		var porcentaje int
-		if manga.CapitulosTot > 0 {
-			porcentaje = (capActual * 100) / manga.CapitulosTot
+// Historial en JSON con los mismos filtros que la página
+func GetHistorialHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
 		}
 
-		data := map[string]interface{}{
-			"Manga":      manga,
-			"Capitulos":  capitulos,
-			"Continue":   capActual,
-			"Progreso":   capActual,
-			"Porcentaje": porcentaje,
-			"Mangas":     mangas,
-			"Usuario":    nombreUsuario,
+		pagina, err := listarHistorial(db, usuarioID, filtroHistorialDesdeQuery(r))
+		if err != nil {
+			http.Error(w, "Error obteniendo historial", http.StatusInternalServerError)
+			return
 		}
 
-		tmpl, _ := template.ParseFiles("web/templates/manga_detalle.html")
-		tmpl.Execute(w, data)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pagina)
 	}
 }
-func ViewCapituloHandler(db *sql.DB) http.HandlerFunc {
+
+func DeleteEntradaHistorialHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		manga := r.URL.Query().Get("manga")
-		cap := r.URL.Query().Get("cap")
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
 
-		basePath := fmt.Sprintf("web/static/uploads/%s/capitulos/", manga)
-		path := basePath + cap + "/"
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 
-		files, err := os.ReadDir(path)
+		err = repository.BorrarEntradaHistorial(db, usuarioID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Entrada no encontrada", http.StatusNotFound)
+			return
+		}
 		if err != nil {
-			http.Error(w, "Capítulo no encontrado", http.StatusNotFound)
+			http.Error(w, "Error borrando historial", http.StatusInternalServerError)
 			return
 		}
 
-		var imagenes []string
-		for _, file := range files {
-			if !file.IsDir() {
-				imagenes = append(imagenes,
-					"/static/uploads/"+manga+"/capitulos/"+cap+"/"+file.Name())
-			}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ClearHistorialHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
 		}
 
-		sort.Strings(imagenes)
+		err = repository.BorrarHistorial(db, usuarioID)
+		if err != nil {
+			http.Error(w, "Error borrando historial", http.StatusInternalServerError)
+			return
+		}
 
-		// 🔹 Guardar progreso automático
-		usuarioID, errUser := getUserIDFromRequest(r)
-		if errUser != nil {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Acciones sobre el estado de los capítulos de un manga:
+// leidos_hasta (capitulo), no_leidos (desde, hasta) y reiniciar
+func CapitulosLeidosHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
-		capituloNum, _ := strconv.Atoi(cap)
-		mangaID, _ := strconv.Atoi(manga)
+		r.ParseForm()
+
+		mangaID, _ := strconv.Atoi(r.FormValue("manga_id"))
+		if _, err := repository.GetMangaByID(db, mangaID); err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		switch r.FormValue("accion") {
+		case "leidos_hasta":
+			numero, _ := strconv.Atoi(r.FormValue("capitulo"))
+			if _, err := repository.GetCapitulo(db, mangaID, numero); err != nil {
+				http.Error(w, "Capítulo no encontrado", http.StatusNotFound)
+				return
+			}
+			err = repository.MarcarLeidosHasta(db, usuarioID, mangaID, numero)
 
-		var capActual int
-		errCheck := db.QueryRow(`
-			SELECT capitulo_actual 
-			FROM lecturas 
-			WHERE usuario_id = ? AND manga_id = ?
-		`, usuarioID, mangaID).Scan(&capActual)
-
-		if errCheck != nil {
-			db.Exec(`
-				INSERT INTO lecturas (usuario_id, manga_id, capitulo_actual)
-				VALUES (?, ?, ?)
-			`, usuarioID, mangaID, capituloNum)
-		} else {
-			if capituloNum > capActual {
-				db.Exec(`
-					UPDATE lecturas 
-					SET capitulo_actual = ?
-					WHERE usuario_id = ? AND manga_id = ?
-				`, capituloNum, usuarioID, mangaID)
+		case "no_leidos":
+			desde, _ := strconv.Atoi(r.FormValue("desde"))
+			hasta, _ := strconv.Atoi(r.FormValue("hasta"))
+			if desde < 1 || hasta < desde {
+				http.Error(w, "Rango inválido", http.StatusBadRequest)
+				return
 			}
+			err = repository.MarcarNoLeidos(db, usuarioID, mangaID, desde, hasta)
+
+		case "reiniciar":
+			err = repository.ReiniciarSerie(db, usuarioID, mangaID)
+
+		default:
+			http.Error(w, "Acción inválida", http.StatusBadRequest)
+			return
 		}
 
-		data := map[string]interface{}{
-			"Imagenes": imagenes,
-			"Manga":    manga,
-			"Capitulo": cap,
+		if err != nil {
+			http.Error(w, "Error guardando progreso", http.StatusInternalServerError)
+			return
 		}
 
-		tmpl, _ := template.ParseFiles("web/templates/view_capitulo.html")
-		tmpl.Execute(w, data)
+		http.Redirect(w, r, fmt.Sprintf("/manga?id=%d", mangaID), http.StatusSeeOther)
 	}
 }
-func getUserIDFromRequest(r *http.Request) (int, error) {
 
-	cookie, err := r.Cookie("auth_token")
-	if err != nil {
-		fmt.Println("No cookie")
-		return 0, err
-	}
+// La página existe en ese capítulo del manga
+func paginaExiste(db *sql.DB, mangaID int, capitulo int, pagina int) bool {
+	c, err := repository.GetCapitulo(db, mangaID, capitulo)
+	return err == nil && pagina >= 1 && pagina <= c.PaginasTot
+}
+
+// Marcadores del usuario en JSON; ?manga= filtra por serie
+func GetMarcadoresHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
 
-	fmt.Println("Token recibido:", cookie.Value)
+		mangaID, _ := strconv.Atoi(r.URL.Query().Get("manga"))
 
-	userID, err := services.GetUserIDFromToken(cookie.Value)
-	fmt.Println("UserID decodificado:", userID, "Error:", err)
+		marcadores, err := repository.GetMarcadores(db, usuarioID, mangaID)
+		if err != nil {
+			http.Error(w, "Error obteniendo marcadores", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(marcadores)
+	}
+}
+
+// {"MangaID": 1, "Capitulo": 3, "Pagina": 12, "Nota": "..."}
+func CreateMarcadorHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
+
+		var marcador models.Marcador
+
+		err = json.NewDecoder(r.Body).Decode(&marcador)
+		if err != nil {
+			http.Error(w, "JSON inválido", http.StatusBadRequest)
+			return
+		}
+
+		if !paginaExiste(db, marcador.MangaID, marcador.Capitulo, marcador.Pagina) {
+			http.Error(w, "Página no encontrada", http.StatusNotFound)
+			return
+		}
+
+		marcador.UsuarioID = usuarioID
+
+		marcador, err = repository.CrearMarcador(db, marcador)
+		if err != nil {
+			http.Error(w, "Error creando marcador", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(marcador)
+	}
+}
+
+// Solo se puede cambiar la nota: {"Nota": "..."}
+func UpdateMarcadorHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
+
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var body struct {
+			Nota string
+		}
+
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, "JSON inválido", http.StatusBadRequest)
+			return
+		}
+
+		err = repository.ActualizarNotaMarcador(db, usuarioID, id, body.Nota)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Marcador no encontrado", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error actualizando marcador", http.StatusInternalServerError)
+			return
+		}
+
+		marcador, err := repository.GetMarcador(db, usuarioID, id)
+		if err != nil {
+			http.Error(w, "Error obteniendo marcador", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(marcador)
+	}
+}
+
+func DeleteMarcadorHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
+
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		err = repository.BorrarMarcador(db, usuarioID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Marcador no encontrado", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error borrando marcador", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Formulario del lector: manga_id, capitulo, pagina, nota y volver
+func CreateMarcadorWebHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		r.ParseForm()
+
+		mangaID, _ := strconv.Atoi(r.FormValue("manga_id"))
+		capitulo, _ := strconv.Atoi(r.FormValue("capitulo"))
+		pagina, _ := strconv.Atoi(r.FormValue("pagina"))
+
+		if !paginaExiste(db, mangaID, capitulo, pagina) {
+			http.Error(w, "Página no encontrada", http.StatusNotFound)
+			return
+		}
+
+		_, err = repository.CrearMarcador(db, models.Marcador{
+			UsuarioID: usuarioID,
+			MangaID:   mangaID,
+			Capitulo:  capitulo,
+			Pagina:    pagina,
+			Nota:      strings.TrimSpace(r.FormValue("nota")),
+		})
+		if err != nil {
+			http.Error(w, "Error creando marcador", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, rutaLocal(r.FormValue("volver"), urlLector(mangaID, capitulo, pagina)), http.StatusSeeOther)
+	}
+}
+
+func DeleteMarcadorWebHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		r.ParseForm()
+
+		id, _ := strconv.Atoi(r.FormValue("id"))
+
+		err = repository.BorrarMarcador(db, usuarioID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Marcador no encontrado", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error borrando marcador", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, rutaLocal(r.FormValue("volver"), "/mangas-web"), http.StatusSeeOther)
+	}
+}
+
+// Caracteres del hash del contenido que van en la URL de una imagen
+const largoHashURL = 16
+
+// URL inmutable de una imagen de web/static: /imagenes/<hash>/<archivo>.
+// Si la imagen cambia, cambia la URL. Sin archivo se usa la portada por
+// defecto
+func urlImagen(archivo string) string {
+	if archivo == "" {
+		archivo = "default.jpg"
+	}
+
+	ruta, err := services.RutaImagen(archivo)
+	if err != nil {
+		return "/static/" + archivo
+	}
+
+	hash, err := services.HashImagen(ruta)
+	if err != nil {
+		return "/static/" + archivo
+	}
+
+	return "/imagenes/" + hash[:largoHashURL] + "/" + archivo
+}
+
+// Funciones disponibles en todas las plantillas
+var funcionesPlantilla = template.FuncMap{
+	"imagen": urlImagen,
+}
+
+func parsePlantilla(archivo string) (*template.Template, error) {
+	return template.New(filepath.Base(archivo)).Funcs(funcionesPlantilla).ParseFiles(archivo)
+}
+
+// Imagen de web/static, opcionalmente redimensionada con ?w=800. Como la
+// URL lleva el hash del contenido se puede cachear para siempre; una URL
+// con un hash antiguo redirige a la actual. Responde a If-None-Match,
+// If-Modified-Since y Range
+func ImagenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		archivo := chi.URLParam(r, "*")
+		hashURL := chi.URLParam(r, "hash")
+		ancho, _ := strconv.Atoi(r.URL.Query().Get("w"))
+
+		ruta, err := services.RutaImagen(archivo)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		// El hash se comprueba antes de redimensionar: una URL inventada no
+		// debe costar una decodificación
+		hash, err := services.HashImagen(ruta)
+		if err != nil {
+			http.Error(w, "Error procesando imagen", http.StatusInternalServerError)
+			return
+		}
+
+		if len(hashURL) != largoHashURL || !strings.HasPrefix(hash, hashURL) {
+			destino := urlImagen(archivo)
+			if r.URL.RawQuery != "" {
+				destino += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Cache-Control", "no-cache")
+			http.Redirect(w, r, destino, http.StatusFound)
+			return
+		}
+
+		ruta, hash, err = services.VarianteImagen(archivo, ancho)
+		if errors.Is(err, services.ErrImagenNoEncontrada) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error procesando imagen", http.StatusInternalServerError)
+			return
+		}
+
+		servirImagen(w, r, ruta, hash, ancho, "public, max-age=31536000, immutable")
+	}
+}
+
+// Sirve la variante ya generada de una imagen con un ETag que depende del
+// contenido original y del ancho
+func servirImagen(w http.ResponseWriter, r *http.Request, ruta string, hash string, ancho int, cacheControl string) {
+	f, err := os.Open(ruta)
+	if err != nil {
+		http.Error(w, "Error procesando imagen", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Error procesando imagen", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, hash, services.AnchoVariante(ancho)))
+	w.Header().Set("Cache-Control", cacheControl)
+
+	http.ServeContent(w, r, ruta, info.ModTime(), f)
+}
+
+// Manga que el usuario puede descargar: hace falta sesión y, si no está
+// disponible, ser admin o editor. Si no, responde y devuelve false
+func mangaDescargable(db *sql.DB, w http.ResponseWriter, r *http.Request) (models.Manga, bool) {
+	if _, err := getUserIDFromRequest(r); err != nil {
+		http.Error(w, "No autorizado", http.StatusUnauthorized)
+		return models.Manga{}, false
+	}
+
+	mangaID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	manga, err := repository.GetMangaByID(db, mangaID)
+	if err != nil || (!manga.Disponible && !puedeEditar(db, r)) {
+		http.Error(w, "Manga no encontrado", http.StatusNotFound)
+		return manga, false
+	}
+
+	return manga, true
+}
+
+func cabecerasDescarga(w http.ResponseWriter, tipo string, nombre string) {
+	w.Header().Set("Content-Type", tipo)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": nombre}))
+}
+
+// Capítulos del manga entre ?desde= y ?hasta= (incluidos, vacío = todos)
+// sin los faltantes. Si no queda ninguno responde y devuelve false
+func capitulosDescargables(db *sql.DB, w http.ResponseWriter, r *http.Request, mangaID int) ([]models.Capitulo, bool) {
+	lista, err := repository.GetCapitulosByManga(db, mangaID)
+	if err != nil {
+		http.Error(w, "Error obteniendo capítulos", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	desde, _ := strconv.Atoi(r.URL.Query().Get("desde"))
+	hasta, _ := strconv.Atoi(r.URL.Query().Get("hasta"))
+
+	var capitulos []models.Capitulo
+	for _, c := range lista {
+		if c.Faltante || c.Numero < desde || (hasta > 0 && c.Numero > hasta) {
+			continue
+		}
+		capitulos = append(capitulos, c)
+	}
+
+	if len(capitulos) == 0 {
+		http.Error(w, "No hay capítulos para descargar", http.StatusNotFound)
+		return nil, false
+	}
+
+	return capitulos, true
+}
+
+// Un capítulo como .cbz
+func DescargarCapituloHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		manga, ok := mangaDescargable(db, w, r)
+		if !ok {
+			return
+		}
+
+		numero, _ := strconv.Atoi(chi.URLParam(r, "numero"))
+
+		capitulo, err := repository.GetCapitulo(db, manga.ID, numero)
+		if err != nil || capitulo.Faltante {
+			http.Error(w, "Capítulo no encontrado", http.StatusNotFound)
+			return
+		}
+
+		cabecerasDescarga(w, "application/vnd.comicbook+zip", services.NombreCBZ(manga, capitulo))
+
+		// Con la respuesta ya empezada solo queda cortar la descarga
+		err = services.EscribirCBZ(db, w, manga, capitulo, direccionDelUsuario(db, r))
+		if err != nil {
+			log.Println("Error generando cbz:", err)
+		}
+	}
+}
+
+// Sentido de lectura del usuario de la petición; vacío si no hay sesión
+func direccionDelUsuario(db *sql.DB, r *http.Request) string {
+	usuarioID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return ""
+	}
+
+	direccion, _ := repository.GetDireccionLectura(db, usuarioID)
+	return direccion
+}
+
+// Toda la serie, o ?desde=&hasta= (incluidos), como un zip de .cbz. Los
+// capítulos faltantes se omiten
+func DescargarSerieHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		manga, ok := mangaDescargable(db, w, r)
+		if !ok {
+			return
+		}
+
+		capitulos, ok := capitulosDescargables(db, w, r, manga.ID)
+		if !ok {
+			return
+		}
+
+		cabecerasDescarga(w, "application/zip", services.NombreDescarga(manga, ".zip"))
+
+		err := services.EscribirSerieZip(db, w, manga, capitulos, direccionDelUsuario(db, r))
+		if err != nil {
+			log.Println("Error generando zip de la serie:", err)
+		}
+	}
+}
+
+// Los capítulos elegidos como un EPUB3 de maquetación fija. ?gris=1 y
+// ?ancho=N preparan las páginas para lectores de tinta electrónica
+func DescargarEPUBHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		manga, ok := mangaDescargable(db, w, r)
+		if !ok {
+			return
+		}
+
+		capitulos, ok := capitulosDescargables(db, w, r, manga.ID)
+		if !ok {
+			return
+		}
+
+		opciones := services.OpcionesEPUB{
+			Gris:      r.URL.Query().Get("gris") == "1",
+			Direccion: models.DireccionRTL,
+		}
+		if d := direccionDelUsuario(db, r); d != "" {
+			opciones.Direccion = d
+		}
+
+		ancho, _ := strconv.Atoi(r.URL.Query().Get("ancho"))
+		for _, a := range services.AnchosEPUB {
+			if ancho == a {
+				opciones.AnchoMax = a
+			}
+		}
+
+		sufijo := fmt.Sprintf(" - Cap %03d", capitulos[0].Numero)
+		if len(capitulos) > 1 {
+			sufijo += fmt.Sprintf("-%03d", capitulos[len(capitulos)-1].Numero)
+		}
+
+		cabecerasDescarga(w, "application/epub+zip", services.NombreDescarga(manga, sufijo+".epub"))
+
+		err := services.EscribirEPUB(db, w, manga, capitulos, opciones)
+		if err != nil {
+			log.Println("Error generando epub:", err)
+		}
+	}
+}
+
+func WebMangasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// 🔹 Obtener usuario desde cookie
+		var nombreUsuario string
+
+		usuarioID, errUser := getUserIDFromRequest(r)
+		if errUser == nil && usuarioID > 0 {
+
+			err := db.QueryRow(
+				"SELECT nombre FROM usuarios WHERE id = ?",
+				usuarioID,
+			).Scan(&nombreUsuario)
+
+			if err != nil {
+				nombreUsuario = ""
+			}
+		}
+
+		// 🔹 Filtros, orden y paginación
+		filtro := filtroDesdeQuery(db, r)
+
+		pagina, err := listarCatalogo(db, filtro)
+		if err != nil {
+			http.Error(w, "Error obteniendo mangas", http.StatusInternalServerError)
+			return
+		}
+
+		generos, idiomas, editoriales, err := repository.OpcionesFiltro(db)
+		if err != nil {
+			http.Error(w, "Error obteniendo filtros", http.StatusInternalServerError)
+			return
+		}
+
+		// 🔹 Enviar datos al template
+		data := map[string]interface{}{
+			"Mangas":      pagina.Mangas,
+			"Pagina":      pagina,
+			"Filtro":      filtro,
+			"Disponible":  r.URL.Query().Get("disponible"),
+			"Generos":     generos,
+			"Idiomas":     idiomas,
+			"Editoriales": editoriales,
+			"Usuario":     nombreUsuario,
+			"PuedeEditar": puedeEditar(db, r),
+		}
+
+		if pagina.Pagina > 1 {
+			data["URLAnterior"] = urlPagina(r, pagina.Pagina-1)
+		}
+		if pagina.Pagina < pagina.Paginas {
+			data["URLSiguiente"] = urlPagina(r, pagina.Pagina+1)
+		}
+
+		tmpl, err := parsePlantilla("web/templates/mangas.html")
+		if err != nil {
+			http.Error(w, "Error cargando template", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl.Execute(w, data)
+	}
+}
+
+// Manga del catálogo con el fragmento resaltado de la búsqueda
+type MangaView struct {
+	models.Manga
+	Fragmento template.HTML
+}
+
+// Página del catálogo con los metadatos de paginación
+type PaginaCatalogo struct {
+	Mangas    []MangaView
+	Total     int
+	Pagina    int
+	PorPagina int
+	Paginas   int
+}
+
+// Lee q, genero, idioma, editorial, disponible, orden, pagina y por_pagina.
+// Solo admin y editores pueden ver mangas no disponibles
+func filtroDesdeQuery(db *sql.DB, r *http.Request) repository.FiltroMangas {
+	q := r.URL.Query()
+
+	pagina, _ := strconv.Atoi(q.Get("pagina"))
+	porPagina, _ := strconv.Atoi(q.Get("por_pagina"))
+
+	filtro := repository.FiltroMangas{
+		Query:     q.Get("q"),
+		Genero:    q.Get("genero"),
+		Idioma:    q.Get("idioma"),
+		Editorial: q.Get("editorial"),
+		Orden:     q.Get("orden"),
+		Pagina:    pagina,
+		PorPagina: porPagina,
+	}
+
+	disponible := true
+	filtro.Disponible = &disponible
+
+	if puedeEditar(db, r) {
+		switch q.Get("disponible") {
+		case "todos":
+			filtro.Disponible = nil
+		case "no":
+			noDisponible := false
+			filtro.Disponible = &noDisponible
+		}
+	}
+
+	filtro.Normalizar()
+	return filtro
+}
+
+func listarCatalogo(db *sql.DB, filtro repository.FiltroMangas) (PaginaCatalogo, error) {
+	resultados, total, err := repository.ListarMangas(db, filtro)
+	if err != nil {
+		return PaginaCatalogo{}, err
+	}
+
+	pagina := PaginaCatalogo{
+		Mangas:    []MangaView{},
+		Total:     total,
+		Pagina:    filtro.Pagina,
+		PorPagina: filtro.PorPagina,
+		Paginas:   (total + filtro.PorPagina - 1) / filtro.PorPagina,
+	}
+
+	for _, res := range resultados {
+		pagina.Mangas = append(pagina.Mangas, MangaView{
+			Manga:     res.Manga,
+			Fragmento: resaltarFragmento(res.Fragmento),
+		})
+	}
+
+	return pagina, nil
+}
+
+// Misma URL con otra página
+func urlPagina(r *http.Request, pagina int) string {
+	q := r.URL.Query()
+	q.Set("pagina", strconv.Itoa(pagina))
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// Escapa el fragmento y convierte las marcas de coincidencia en <mark>
+func resaltarFragmento(fragmento string) template.HTML {
+	html := template.HTMLEscapeString(fragmento)
+	html = strings.ReplaceAll(html, repository.MarcaInicio, "<mark>")
+	html = strings.ReplaceAll(html, repository.MarcaFin, "</mark>")
+	return template.HTML(html)
+}
+func ListMangasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		pagina, err := listarCatalogo(db, filtroDesdeQuery(db, r))
+		if err != nil {
+			http.Error(w, "Error obteniendo mangas", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pagina)
+	}
+}
+
+func CreateMangaFormHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		tmpl, err := parsePlantilla("web/templates/create_manga.html")
+		if err != nil {
+			http.Error(w, "Error cargando formulario", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl.Execute(w, nil)
+	}
+}
+func CreateMangaWebHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		r.Body = http.MaxBytesReader(w, r.Body, services.MaxArchivoCapitulo+(1<<20))
+
+		err := r.ParseMultipartForm(32 << 20)
+		if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			http.Error(w, "Error procesando formulario", http.StatusBadRequest)
+			return
+		}
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+
+		manga := mangaDelFormulario(r)
+
+		// 🔹 Primer capítulo opcional: sus metadatos completan el formulario
+		file, header, errFile := r.FormFile("archivo")
+		var info *services.ComicInfo
+
+		if errFile == nil {
+			defer file.Close()
+
+			if !services.EsArchivoCapitulo(header.Filename) {
+				http.Error(w, "Solo se aceptan archivos .cbz o .zip", http.StatusBadRequest)
+				return
+			}
+
+			info, err = services.LeerComicInfo(file, header.Size)
+			if err != nil {
+				errorImportacion(w, err)
+				return
+			}
+
+			if info != nil {
+				info.CompletarManga(&manga)
+			}
+		}
+
+		if manga.Titulo == "" || manga.Autor == "" {
+			http.Error(w, "Título y autor son obligatorios", http.StatusBadRequest)
+			return
+		}
+
+		// 🔹 Portada opcional: sin ella se usa la primera página
+		portada, ok := portadaDelFormulario(w, r)
+		if !ok {
+			return
+		}
+		if portada != nil {
+			defer portada.Close()
+		}
+
+		mangaID, err := repository.CreateManga(db, manga)
+		if err != nil {
+			http.Error(w, "Error guardando manga", http.StatusInternalServerError)
+			return
+		}
+
+		// 🔹 Si la portada o el capítulo fallan no queda un manga a medias
+		if portada != nil {
+			err = services.GuardarPortada(db, mangaID, portada)
+			if err != nil {
+				services.EliminarManga(db, mangaID)
+				http.Error(w, "Error guardando portada", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if errFile != nil {
+			http.Redirect(w, r, "/mangas-web", http.StatusSeeOther)
+			return
+		}
+
+		capitulo := models.Capitulo{MangaID: mangaID, Numero: 1}
+		if info != nil {
+			if numero, ok := info.NumeroCapitulo(); ok {
+				capitulo.Numero = numero
+			}
+		}
+
+		_, err = services.ImportarArchivoCapitulo(db, capitulo, file, header.Size)
+		if err != nil {
+			services.EliminarManga(db, mangaID)
+			errorImportacion(w, err)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/manga?id=%d", mangaID), http.StatusSeeOther)
+	}
+}
+func mangaDelFormulario(r *http.Request) models.Manga {
+	disponible := false
+	if r.FormValue("disponible") == "true" {
+		disponible = true
+	}
+
+	return models.Manga{
+		Titulo:      r.FormValue("titulo"),
+		Autor:       r.FormValue("autor"),
+		Genero:      r.FormValue("genero"),
+		Idioma:      r.FormValue("idioma"),
+		Editorial:   r.FormValue("editorial"),
+		Descripcion: r.FormValue("descripcion"),
+		Disponible:  disponible,
+	}
+}
+
+// Portada opcional del formulario ya validada; nil si no se subió.
+// Si es inválida responde el error y devuelve ok = false
+func portadaDelFormulario(w http.ResponseWriter, r *http.Request) (multipart.File, bool) {
+	portada, header, err := r.FormFile("portada")
+	if err != nil {
+		return nil, true
+	}
+
+	if header.Size > services.MaxPortada {
+		portada.Close()
+		http.Error(w, "La portada es demasiado grande", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+
+	if _, err := services.ExtensionImagen(portada); err != nil {
+		portada.Close()
+		http.Error(w, "La portada debe ser una imagen", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return portada, true
+}
+func EditMangaFormHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		mangaID, _ := strconv.Atoi(r.URL.Query().Get("id"))
+
+		manga, err := repository.GetMangaByID(db, mangaID)
+		if err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		tmpl, err := parsePlantilla("web/templates/edit_manga.html")
+		if err != nil {
+			http.Error(w, "Error cargando formulario", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl.Execute(w, map[string]interface{}{
+			"Manga": manga,
+		})
+	}
+}
+func UpdateMangaWebHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		r.Body = http.MaxBytesReader(w, r.Body, services.MaxPortada+(1<<20))
+
+		err := r.ParseMultipartForm(services.MaxPortada)
+		if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			http.Error(w, "Error procesando formulario", http.StatusBadRequest)
+			return
+		}
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+
+		mangaID, _ := strconv.Atoi(r.FormValue("id"))
+
+		if _, err := repository.GetMangaByID(db, mangaID); err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		manga := mangaDelFormulario(r)
+		manga.ID = mangaID
+
+		if manga.Titulo == "" || manga.Autor == "" {
+			http.Error(w, "Título y autor son obligatorios", http.StatusBadRequest)
+			return
+		}
+
+		portada, ok := portadaDelFormulario(w, r)
+		if !ok {
+			return
+		}
+		if portada != nil {
+			defer portada.Close()
+		}
+
+		err = repository.UpdateManga(db, manga)
+		if err != nil {
+			http.Error(w, "Error guardando manga", http.StatusInternalServerError)
+			return
+		}
+
+		if portada != nil {
+			err = services.GuardarPortada(db, mangaID, portada)
+			if err != nil {
+				http.Error(w, "Error guardando portada", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/manga?id=%d", mangaID), http.StatusSeeOther)
+	}
+}
+func DeleteMangaWebHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		r.ParseForm()
+
+		mangaID, _ := strconv.Atoi(r.FormValue("id"))
+
+		if _, err := repository.GetMangaByID(db, mangaID); err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		err := services.EliminarManga(db, mangaID)
+		if err != nil {
+			http.Error(w, "Error eliminando manga", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/mangas-web", http.StatusSeeOther)
+	}
+}
+func UpdateMangaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		mangaID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		actual, err := repository.GetMangaByID(db, mangaID)
+		if err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		var manga models.Manga
+
+		err = json.NewDecoder(r.Body).Decode(&manga)
+		if err != nil {
+			http.Error(w, "JSON inválido", http.StatusBadRequest)
+			return
+		}
+
+		if manga.Titulo == "" || manga.Autor == "" {
+			http.Error(w, "Título y autor son obligatorios", http.StatusBadRequest)
+			return
+		}
+
+		// Los capítulos y la portada no se editan desde aquí
+		manga.ID = actual.ID
+		manga.CapitulosTot = actual.CapitulosTot
+		manga.Portada = actual.Portada
+
+		err = repository.UpdateManga(db, manga)
+		if err != nil {
+			http.Error(w, "Error guardando manga", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(manga)
+	}
+}
+func DeleteMangaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		mangaID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		if _, err := repository.GetMangaByID(db, mangaID); err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		err := services.EliminarManga(db, mangaID)
+		if err != nil {
+			http.Error(w, "Error eliminando manga", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+func UploadCapituloFormHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		mangaID, _ := strconv.Atoi(r.URL.Query().Get("manga"))
+
+		manga, err := repository.GetMangaByID(db, mangaID)
+		if err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		tmpl, err := parsePlantilla("web/templates/upload_capitulo.html")
+		if err != nil {
+			http.Error(w, "Error cargando formulario", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl.Execute(w, map[string]interface{}{
+			"Manga":     manga,
+			"Siguiente": manga.CapitulosTot + 1,
+		})
+	}
+}
+func UploadCapituloHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		r.Body = http.MaxBytesReader(w, r.Body, services.MaxArchivoCapitulo+(1<<20))
+
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, "Archivo demasiado grande o formulario inválido", http.StatusRequestEntityTooLarge)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		mangaID, _ := strconv.Atoi(r.FormValue("manga_id"))
+
+		// Vacío = tomar el número de ComicInfo.xml
+		var numero int
+		if r.FormValue("numero") != "" {
+			n, err := strconv.Atoi(r.FormValue("numero"))
+			if err != nil || n <= 0 {
+				http.Error(w, "Número de capítulo inválido", http.StatusBadRequest)
+				return
+			}
+			numero = n
+		}
+
+		if _, err := repository.GetMangaByID(db, mangaID); err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		file, header, err := r.FormFile("archivo")
+		if err != nil {
+			http.Error(w, "Archivo requerido", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if !services.EsArchivoCapitulo(header.Filename) {
+			http.Error(w, "Solo se aceptan archivos .cbz o .zip", http.StatusBadRequest)
+			return
+		}
+
+		capitulo := models.Capitulo{
+			MangaID: mangaID,
+			Numero:  numero,
+			Titulo:  r.FormValue("titulo"),
+		}
+
+		_, err = services.ImportarArchivoCapitulo(db, capitulo, file, header.Size)
+		if err != nil {
+			errorImportacion(w, err)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/manga?id=%d", mangaID), http.StatusSeeOther)
+	}
+}
+
+// Traduce los errores de importación de archivos a respuestas HTTP
+func errorImportacion(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrCapituloExiste):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrArchivoGrande):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, services.ErrArchivoInvalido),
+		errors.Is(err, services.ErrRutaInsegura),
+		errors.Is(err, services.ErrNoEsImagen),
+		errors.Is(err, services.ErrSinPaginas),
+		errors.Is(err, services.ErrNumeroCapitulo):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error guardando capítulo", http.StatusInternalServerError)
+	}
+}
+func EscanearBibliotecaHandler(escaner *services.Escaner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		res, err := escaner.Escanear()
+		if err != nil {
+			http.Error(w, "Error escaneando biblioteca", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
+func ViewMangaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		mangaIDStr := r.URL.Query().Get("id")
+		mangaID, _ := strconv.Atoi(mangaIDStr)
+
+		// Obtener manga
+		var mangas []models.Manga
+		manga, err := repository.GetMangaByID(db, mangaID)
+		if err != nil {
+			http.Error(w, "Manga no encontrado", http.StatusNotFound)
+			return
+		}
+
+		lista, err := repository.GetCapitulosByManga(db, manga.ID)
+		if err != nil {
+			http.Error(w, "Error obteniendo capítulos", http.StatusInternalServerError)
+			return
+		}
+
+		type CapituloView struct {
+			Numero   int
+			Titulo   string
+			Leido    bool
+			Veces    int
+			Actual   bool
+			Faltante bool
+			// Primera página, para la miniatura
+			Miniatura string
+		}
+
+		var capitulos []CapituloView
+
+		// Obtener progreso
+		usuarioID, errUser := getUserIDFromRequest(r)
+
+		var nombreUsuario string
+
+		if errUser == nil {
+			db.QueryRow("SELECT nombre FROM usuarios WHERE id = ?", usuarioID).Scan(&nombreUsuario)
+		}
+
+		lectura, _ := repository.GetLectura(db, usuarioID, manga.ID)
+
+		estados := map[int]models.CapituloLeido{}
+		if errUser == nil {
+			estados, err = repository.GetCapitulosLeidos(db, usuarioID, manga.ID)
+			if err != nil {
+				http.Error(w, "Error obteniendo progreso", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		miniaturas, err := repository.GetPrimerasPaginas(db, manga.ID)
+		if err != nil {
+			http.Error(w, "Error obteniendo capítulos", http.StatusInternalServerError)
+			return
+		}
+
+		var leidos int
+		for _, capitulo := range lista {
+			c := CapituloView{
+				Numero:    capitulo.Numero,
+				Titulo:    capitulo.Titulo,
+				Faltante:  capitulo.Faltante,
+				Miniatura: miniaturas[capitulo.Numero],
+			}
+
+			if estado, ok := estados[capitulo.Numero]; ok {
+				c.Leido = estado.Leido
+				c.Veces = estado.Veces
+				if estado.Leido {
+					leidos++
+				}
+			}
+			if capitulo.Numero == lectura.CapituloActual {
+				c.Actual = true
+			}
+
+			capitulos = append(capitulos, c)
+		}
+
+		var porcentaje int
+		if len(lista) > 0 {
+			porcentaje = (leidos * 100) / len(lista)
+		}
+
+		// Se retoma en la página guardada, o en el siguiente capítulo si
+		// el actual ya se terminó. Tras reiniciar la serie se empieza por
+		// el primero
+		var continuar string
+		capContinuar := lectura.CapituloActual
+		if lectura.ID != 0 && lectura.CapituloActual == 0 && len(lista) > 0 {
+			capContinuar = lista[0].Numero
+			continuar = urlLector(manga.ID, lista[0].Numero, 1)
+		}
+		if lectura.CapituloActual > 0 {
+			continuar = urlLector(manga.ID, lectura.CapituloActual, max(lectura.PaginaActual, 1))
+
+			if estados[lectura.CapituloActual].Leido {
+				if _, next := capitulosVecinos(lista, lectura.CapituloActual); next != nil {
+					capContinuar = next.Numero
+					continuar = urlLector(manga.ID, next.Numero, 1)
+				}
+			}
+		}
+
+		var marcadores []models.Marcador
+		if errUser == nil {
+			marcadores, err = repository.GetMarcadores(db, usuarioID, manga.ID)
+			if err != nil {
+				http.Error(w, "Error obteniendo marcadores", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		data := map[string]interface{}{
+			"Manga":        manga,
+			"Capitulos":    capitulos,
+			"Continue":     capContinuar,
+			"URLContinue":  continuar,
+			"Progreso":     leidos,
+			"Porcentaje":   porcentaje,
+			"Mangas":       mangas,
+			"Usuario":      nombreUsuario,
+			"PuedeEditar":  puedeEditar(db, r),
+			"Lectura":      lectura,
+			"Estados":      opcionesEstado(),
+			"Puntuaciones": []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			"Marcadores":   marcadores,
+			"AnchosEPUB":   services.AnchosEPUB,
+		}
+
+		tmpl, _ := parsePlantilla("web/templates/manga_detalle.html")
+		tmpl.Execute(w, data)
+	}
+}
+func ViewCapituloHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		manga := r.URL.Query().Get("manga")
+		cap := r.URL.Query().Get("cap")
+
+		mangaID, _ := strconv.Atoi(manga)
+		capituloNum, _ := strconv.Atoi(cap)
+
+		capitulo, err := repository.GetCapitulo(db, mangaID, capituloNum)
+		if err != nil {
+			http.Error(w, "Capítulo no encontrado", http.StatusNotFound)
+			return
+		}
+
+		paginas, err := repository.GetPaginasByCapitulo(db, capitulo.ID)
+		if err != nil {
+			http.Error(w, "Error obteniendo páginas", http.StatusInternalServerError)
+			return
+		}
+
+		// Rutas relativas a web/static; la plantilla pide el tamaño
+		var imagenes []string
+		for _, pagina := range paginas {
+			imagenes = append(imagenes, pagina.Archivo)
+		}
+
+		lista, err := repository.GetCapitulosByManga(db, mangaID)
+		if err != nil {
+			http.Error(w, "Error obteniendo capítulos", http.StatusInternalServerError)
+			return
+		}
+
+		prev, next := capitulosVecinos(lista, capitulo.Numero)
+
+		usuarioID, errUser := getUserIDFromRequest(r)
+
+		direccion := models.DireccionRTL
+		if errUser == nil {
+			if d, err := repository.GetDireccionLectura(db, usuarioID); err == nil {
+				direccion = d
+			}
+		}
+
+		data := map[string]interface{}{
+			"Imagenes":    imagenes,
+			"Manga":       manga,
+			"MangaID":     mangaID,
+			"Capitulo":    cap,
+			"CapituloNum": capitulo.Numero,
+			"Titulo":      capitulo.Titulo,
+			"Direccion":   direccion,
+			"Usuario":     errUser == nil,
+			"URLActual":   r.URL.RequestURI(),
+		}
+
+		if prev != nil {
+			data["Prev"] = prev.Numero
+		}
+		if next != nil {
+			data["Next"] = next.Numero
+		}
+
+		// Marcadores del usuario en este capítulo
+		var marcadores []models.Marcador
+		if errUser == nil {
+			todos, err := repository.GetMarcadores(db, usuarioID, mangaID)
+			if err != nil {
+				http.Error(w, "Error obteniendo marcadores", http.StatusInternalServerError)
+				return
+			}
+			for _, m := range todos {
+				if m.Capitulo == capitulo.Numero {
+					marcadores = append(marcadores, m)
+				}
+			}
+		}
+		data["Marcadores"] = marcadores
+
+		paginaLeida := 1
+
+		// 🔹 Modo por páginas (?page=N). Al pasar de la última página se
+		// sigue en el capítulo siguiente, y de la primera al anterior
+		if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+			page, err := strconv.Atoi(pageStr)
+			if err != nil || page < 1 || page > len(imagenes) {
+				http.Error(w, "Página no encontrada", http.StatusNotFound)
+				return
+			}
+
+			paginaLeida = page
+
+			for _, m := range marcadores {
+				if m.Pagina == page {
+					data["Marcador"] = m
+				}
+			}
+
+			data["Paginado"] = true
+			data["Pagina"] = page
+			data["PaginasTot"] = len(imagenes)
+			data["Imagen"] = imagenes[page-1]
+
+			switch {
+			case page > 1:
+				data["URLAnterior"] = urlLector(mangaID, capitulo.Numero, page-1)
+			case prev != nil:
+				data["URLAnterior"] = urlLector(mangaID, prev.Numero, max(prev.PaginasTot, 1))
+			}
+
+			switch {
+			case page < len(imagenes):
+				data["URLSiguiente"] = urlLector(mangaID, capitulo.Numero, page+1)
+			case next != nil:
+				data["URLSiguiente"] = urlLector(mangaID, next.Numero, 1)
+			}
+		}
+
+		// 🔹 Guardar progreso automático: en modo por páginas cuenta la
+		// página mostrada; en tira larga la envía el lector al hacer scroll
+		if errUser == nil {
+			terminado := terminaCapitulo(db, usuarioID, capitulo, paginaLeida, len(imagenes))
+			repository.GuardarProgresoPagina(db, usuarioID, mangaID, capitulo.Numero, paginaLeida, terminado)
+			repository.RegistrarHistorial(db, models.EntradaHistorial{
+				UsuarioID: usuarioID,
+				MangaID:   mangaID,
+				Capitulo:  capitulo.Numero,
+				Agente:    r.UserAgent(),
+			})
+		}
+
+		tmpl, _ := parsePlantilla("web/templates/view_capitulo.html")
+		tmpl.Execute(w, data)
+	}
+}
+
+// Capítulos anterior y siguiente dentro de la lista ordenada por número
+func capitulosVecinos(capitulos []models.Capitulo, numero int) (prev *models.Capitulo, next *models.Capitulo) {
+	for i := range capitulos {
+		if capitulos[i].Numero < numero {
+			prev = &capitulos[i]
+		}
+		if capitulos[i].Numero > numero && next == nil {
+			next = &capitulos[i]
+		}
+	}
+	return prev, next
+}
+
+func urlLector(mangaID int, capitulo int, pagina int) string {
+	return fmt.Sprintf("/capitulo?manga=%d&cap=%d&page=%d", mangaID, capitulo, pagina)
+}
+
+func DireccionLecturaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		usuarioID, err := getUserIDFromRequest(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		r.ParseForm()
+
+		direccion := r.FormValue("direccion")
+		if direccion != models.DireccionRTL && direccion != models.DireccionLTR {
+			http.Error(w, "Dirección inválida", http.StatusBadRequest)
+			return
+		}
+
+		err = repository.ActualizarDireccionLectura(db, usuarioID, direccion)
+		if err != nil {
+			http.Error(w, "Error guardando preferencia", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, rutaLocal(r.FormValue("volver"), "/mangas-web"), http.StatusSeeOther)
+	}
+}
+
+// Solo se vuelve a rutas locales; si no, a porDefecto.
+// Los navegadores tratan "\" como "/" e ignoran tabuladores y saltos de línea,
+// así que "/\evil.com" acabaría en otro dominio
+func rutaLocal(volver string, porDefecto string) string {
+	if strings.ContainsFunc(volver, func(c rune) bool { return c == '\\' || c < 0x20 || c == 0x7f }) {
+		return porDefecto
+	}
+
+	u, err := url.Parse(volver)
+	if err != nil || u.Scheme != "" || u.Host != "" ||
+		!strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") {
+		return porDefecto
+	}
+	return volver
+}
+
+func getUserIDFromRequest(r *http.Request) (int, error) {
+
+	tokenString := tokenFromRequest(r)
+	if tokenString == "" {
+		return 0, http.ErrNoCookie
+	}
 
-	return userID, err
+	return services.GetUserIDFromToken(tokenString)
 }
 func RegisterFormHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmpl, _ := template.ParseFiles("web/templates/register.html")
+		tmpl, _ := parsePlantilla("web/templates/register.html")
 		tmpl.Execute(w, nil)
 	}
 }
@@ -511,7 +2111,7 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 }
 func LoginFormHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmpl, _ := template.ParseFiles("web/templates/login.html")
+		tmpl, _ := parsePlantilla("web/templates/login.html")
 		tmpl.Execute(w, nil)
 	}
 }
@@ -526,10 +2126,10 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 		var user models.Usuario
 
 		err := db.QueryRow(`
-			SELECT id, nombre, password 
+			SELECT id, nombre, password, rol 
 			FROM usuarios 
 			WHERE email = ?
-		`, email).Scan(&user.ID, &user.Nombre, &user.Password)
+		`, email).Scan(&user.ID, &user.Nombre, &user.Password, &user.Rol)
 
 		if err != nil {
 			http.Error(w, "Usuario no encontrado", http.StatusUnauthorized)
@@ -542,7 +2142,7 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		token, _ := services.GenerateJWT(user.ID)
+		token, _ := services.GenerateJWT(user.ID, user.Rol)
 
 		http.SetCookie(w, &http.Cookie{
 			Name:  "auth_token",
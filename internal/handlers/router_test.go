@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+	"github.com/Graynie/InkZen/internal/services"
+)
+
+// Base de datos vacía en un directorio temporal, con un manga de un
+// capítulo de tres páginas
+func baseDePrueba(t *testing.T) (*sql.DB, models.Capitulo) {
+	t.Chdir(t.TempDir())
+
+	err := os.Mkdir("db", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := repository.NewDatabase()
+	t.Cleanup(func() { db.Close() })
+
+	_, err = repository.Migrar(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mangaID, err := repository.CreateManga(db, models.Manga{Titulo: "Prueba", Disponible: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capitulo := models.Capitulo{MangaID: mangaID, Numero: 1}
+	capitulo.ID, err = repository.CreateCapitulo(db, capitulo, []string{"a.png", "b.png", "c.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	capitulo.PaginasTot = 3
+
+	return db, capitulo
+}
+
+func usuarioDePrueba(t *testing.T, db *sql.DB, email string) (int, string) {
+	err := repository.CreateUser(db, models.Usuario{Nombre: email, Email: email, Password: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repository.GetUserByEmail(db, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := services.GenerateJWT(user.ID, user.Rol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user.ID, token
+}
+
+func enviarProgreso(t *testing.T, db *sql.DB, token string, capitulo models.Capitulo, pagina int) {
+	body := fmt.Sprintf(`{"MangaID": %d, "Capitulo": %d, "Pagina": %d}`, capitulo.MangaID, capitulo.Numero, pagina)
+
+	req := httptest.NewRequest(http.MethodPost, "/lecturas/progreso", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+
+	ProgresoLecturaHandler(db).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("página %d: %d %s", pagina, rec.Code, rec.Body.String())
+	}
+}
+
+func capituloLeido(t *testing.T, db *sql.DB, usuarioID int, capitulo models.Capitulo) bool {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM capitulos_leidos WHERE usuario_id = ? AND capitulo_id = ? AND leido",
+		usuarioID, capitulo.ID,
+	).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+// Saltar directamente a la última página no marca el capítulo como leído;
+// llegar a ella desde la anterior sí
+func TestProgresoSaltoALaUltimaPagina(t *testing.T) {
+	db, capitulo := baseDePrueba(t)
+
+	saltaID, salta := usuarioDePrueba(t, db, "salta@prueba")
+
+	enviarProgreso(t, db, salta, capitulo, 3)
+	if capituloLeido(t, db, saltaID, capitulo) {
+		t.Error("el salto a la última página marcó el capítulo como leído")
+	}
+
+	lectura, err := repository.GetLectura(db, saltaID, capitulo.MangaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lectura.PaginaActual != 3 {
+		t.Errorf("página actual = %d, quiero 3", lectura.PaginaActual)
+	}
+
+	leeID, lee := usuarioDePrueba(t, db, "lee@prueba")
+
+	for pagina := 1; pagina <= 3; pagina++ {
+		enviarProgreso(t, db, lee, capitulo, pagina)
+	}
+	if !capituloLeido(t, db, leeID, capitulo) {
+		t.Error("leer en orden hasta la última página no marcó el capítulo")
+	}
+}
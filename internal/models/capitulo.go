@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+type Capitulo struct {
+	ID               int
+	MangaID          int
+	Numero           int
+	Titulo           string
+	FechaPublicacion time.Time
+	PaginasTot       int
+	// Ruta en la biblioteca de la que se importó (vacía si se subió por web)
+	Origen    string
+	OrigenMod int64
+	Faltante  bool
+}
+
+type Pagina struct {
+	ID         int
+	CapituloID int
+	Numero     int
+	Archivo    string
+}
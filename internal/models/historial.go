@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Capítulo abierto por un usuario. Agente es el User-Agent del dispositivo
+type EntradaHistorial struct {
+	ID        int
+	UsuarioID int
+	MangaID   int
+	Capitulo  int
+	Fecha     time.Time
+	Agente    string
+
+	// Título del manga, para mostrar el historial
+	MangaTitulo string
+}
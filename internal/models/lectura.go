@@ -1,8 +1,75 @@
 package models
 
+import "time"
+
+// Estados de una lectura en la biblioteca del usuario
+const (
+	EstadoLeyendo    = "leyendo"
+	EstadoCompletado = "completado"
+	EstadoEnPausa    = "en_pausa"
+	EstadoAbandonado = "abandonado"
+	EstadoPendiente  = "pendiente"
+)
+
+// Orden en que se muestran las estanterías
+var EstadosLectura = []string{
+	EstadoLeyendo,
+	EstadoPendiente,
+	EstadoEnPausa,
+	EstadoCompletado,
+	EstadoAbandonado,
+}
+
+var nombresEstado = map[string]string{
+	EstadoLeyendo:    "Leyendo",
+	EstadoCompletado: "Completado",
+	EstadoEnPausa:    "En pausa",
+	EstadoAbandonado: "Abandonado",
+	EstadoPendiente:  "Pendiente",
+}
+
+func EstadoValido(estado string) bool {
+	_, ok := nombresEstado[estado]
+	return ok
+}
+
+func NombreEstado(estado string) string {
+	return nombresEstado[estado]
+}
+
+// Puntuación personal: 0 sin puntuar, 1 a 10
+const MaxPuntuacion = 10
+
 type Lectura struct {
 	ID             int
 	UsuarioID      int
 	MangaID        int
 	CapituloActual int
+	// Última página alcanzada en CapituloActual
+	PaginaActual int
+	// Veces que se ha vuelto a empezar la serie
+	Relecturas int
+
+	Estado     string
+	Puntuacion int
+	// Cero si no se han registrado
+	Iniciada      time.Time
+	UltimaLectura time.Time
+	Terminada     time.Time
+}
+
+// Estado de un capítulo para un usuario. Un capítulo cuenta como leído
+// al llegar a su última página o al marcarlo a mano; Veces cuenta cuántas
+// veces se ha terminado
+type CapituloLeido struct {
+	CapituloID int
+	Leido      bool
+	Veces      int
+	Fecha      time.Time
+}
+
+// Lectura con los datos del manga, para la biblioteca
+type EntradaBiblioteca struct {
+	Lectura
+	Manga Manga
 }
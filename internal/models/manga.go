@@ -10,4 +10,13 @@ type Manga struct {
 	Descripcion  string
 	CapitulosTot int
 	Disponible   bool
+	// Ruta relativa a web/static (vacía si no tiene portada)
+	Portada string
+}
+
+// Manga encontrado por la búsqueda, con el fragmento que coincide.
+// En Fragmento los términos encontrados van entre \x02 y \x03
+type ResultadoBusqueda struct {
+	Manga
+	Fragmento string
 }
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Marcador de un usuario en una página de un capítulo
+type Marcador struct {
+	ID        int
+	UsuarioID int
+	MangaID   int
+	Capitulo  int
+	Pagina    int
+	Nota      string
+	Creado    time.Time
+}
@@ -1,8 +1,23 @@
 package models
 
+const (
+	RolAdmin  = "admin"
+	RolEditor = "editor"
+	RolLector = "lector"
+)
+
+// Sentido de lectura en el modo por páginas
+const (
+	DireccionRTL = "rtl"
+	DireccionLTR = "ltr"
+)
+
 type Usuario struct {
 	ID       int
 	Nombre   string
 	Email    string
 	Password string
+	Rol      string
+
+	DireccionLectura string
 }
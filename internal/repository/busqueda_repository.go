@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Graynie/InkZen/internal/models"
+)
+
+// Marcas que rodean los términos encontrados en ResultadoBusqueda.Fragmento
+const (
+	MarcaInicio = "\x02"
+	MarcaFin    = "\x03"
+)
+
+// Órdenes del catálogo. Sin orden se usa relevancia si hay búsqueda y
+// título si no
+const (
+	OrdenRelevancia   = "relevancia"
+	OrdenTitulo       = "titulo"
+	OrdenRecientes    = "recientes"
+	OrdenActualizados = "actualizados"
+	OrdenPopulares    = "populares"
+)
+
+const MaxPorPagina = 100
+
+type FiltroMangas struct {
+	// Texto libre: búsqueda en título, autor, género, editorial,
+	// descripción y títulos de capítulos
+	Query     string
+	Genero    string
+	Idioma    string
+	Editorial string
+	// nil = disponibles y no disponibles
+	Disponible *bool
+	Orden      string
+	Pagina     int
+	PorPagina  int
+}
+
+// Normaliza página, tamaño y orden
+func (f *FiltroMangas) Normalizar() {
+	if f.Pagina < 1 {
+		f.Pagina = 1
+	}
+	if f.PorPagina < 1 || f.PorPagina > MaxPorPagina {
+		f.PorPagina = 24
+	}
+
+	switch f.Orden {
+	case OrdenTitulo, OrdenRecientes, OrdenActualizados, OrdenPopulares:
+	case OrdenRelevancia:
+		if consultaFTS(f.Query) == "" {
+			f.Orden = OrdenTitulo
+		}
+	default:
+		f.Orden = OrdenTitulo
+		if consultaFTS(f.Query) != "" {
+			f.Orden = OrdenRelevancia
+		}
+	}
+}
+
+// Lista el catálogo filtrado, ordenado y paginado. Devuelve también el
+// total de mangas que cumplen el filtro
+func ListarMangas(db *sql.DB, filtro FiltroMangas) ([]models.ResultadoBusqueda, int, error) {
+	filtro.Normalizar()
+
+	from := " FROM mangas m"
+	var where []string
+	var args []interface{}
+
+	fragmento := "''"
+	var fragmentoArgs []interface{}
+
+	if consulta := consultaFTS(filtro.Query); consulta != "" {
+		from += " JOIN mangas_fts ON mangas_fts.rowid = m.id"
+		where = append(where, "mangas_fts MATCH ?")
+		args = append(args, consulta)
+
+		fragmento = "snippet(mangas_fts, -1, ?, ?, '…', 16)"
+		fragmentoArgs = []interface{}{MarcaInicio, MarcaFin}
+	}
+
+	if filtro.Genero != "" {
+		// genero puede tener varios separados por comas
+		where = append(where, "(',' || replace(lower(m.genero), ', ', ',') || ',') LIKE ? ESCAPE '\\'")
+		args = append(args, "%,"+escaparLike(strings.ToLower(filtro.Genero))+",%")
+	}
+	if filtro.Idioma != "" {
+		where = append(where, "m.idioma = ? COLLATE NOCASE")
+		args = append(args, filtro.Idioma)
+	}
+	if filtro.Editorial != "" {
+		where = append(where, "m.editorial = ? COLLATE NOCASE")
+		args = append(args, filtro.Editorial)
+	}
+	if filtro.Disponible != nil {
+		where = append(where, "m.disponible = ?")
+		args = append(args, *filtro.Disponible)
+	}
+
+	if len(where) > 0 {
+		from += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	err := db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var orden string
+	switch filtro.Orden {
+	case OrdenRelevancia:
+		orden = "bm25(mangas_fts, 10.0, 5.0, 3.0, 3.0, 1.0, 2.0)"
+	case OrdenRecientes:
+		orden = "m.id DESC"
+	case OrdenActualizados:
+		orden = "(SELECT MAX(c.fecha_publicacion) FROM capitulos c WHERE c.manga_id = m.id) DESC NULLS LAST, m.id DESC"
+	case OrdenPopulares:
+		orden = "(SELECT COUNT(*) FROM lecturas l WHERE l.manga_id = m.id) DESC, m.titulo COLLATE NOCASE"
+	default:
+		orden = "m.titulo COLLATE NOCASE, m.id"
+	}
+
+	query := "SELECT " + prefijarColumnas("m", mangaColumnas) + ", " + fragmento +
+		from + " ORDER BY " + orden + " LIMIT ? OFFSET ?"
+
+	queryArgs := append(fragmentoArgs, args...)
+	queryArgs = append(queryArgs, filtro.PorPagina, (filtro.Pagina-1)*filtro.PorPagina)
+
+	rows, err := db.Query(query, queryArgs...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var resultados []models.ResultadoBusqueda
+
+	for rows.Next() {
+		var r models.ResultadoBusqueda
+		var err error
+		r.Manga, err = scanManga(rows, &r.Fragmento)
+		if err != nil {
+			return nil, 0, err
+		}
+		resultados = append(resultados, r)
+	}
+
+	return resultados, total, rows.Err()
+}
+
+// Valores existentes de género, idioma y editorial para los filtros
+func OpcionesFiltro(db *sql.DB) (generos []string, idiomas []string, editoriales []string, err error) {
+	rows, err := db.Query("SELECT coalesce(genero, ''), coalesce(idioma, ''), coalesce(editorial, '') FROM mangas")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+
+	g, i, e := map[string]bool{}, map[string]bool{}, map[string]bool{}
+
+	for rows.Next() {
+		var genero, idioma, editorial string
+		if err := rows.Scan(&genero, &idioma, &editorial); err != nil {
+			return nil, nil, nil, err
+		}
+
+		for _, parte := range strings.Split(genero, ",") {
+			if parte = strings.TrimSpace(parte); parte != "" {
+				g[parte] = true
+			}
+		}
+		if idioma = strings.TrimSpace(idioma); idioma != "" {
+			i[idioma] = true
+		}
+		if editorial = strings.TrimSpace(editorial); editorial != "" {
+			e[editorial] = true
+		}
+	}
+
+	return claves(g), claves(i), claves(e), rows.Err()
+}
+
+func claves(m map[string]bool) []string {
+	var lista []string
+	for k := range m {
+		lista = append(lista, k)
+	}
+	sort.Strings(lista)
+	return lista
+}
+
+// Convierte el texto del usuario en una consulta FTS5 segura: cada palabra
+// entre comillas y como prefijo, todas obligatorias
+func consultaFTS(q string) string {
+	palabras := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var terminos []string
+	for _, p := range palabras {
+		terminos = append(terminos, `"`+p+`"*`)
+	}
+
+	return strings.Join(terminos, " ")
+}
+
+// "id, titulo" -> "m.id, m.titulo"
+func prefijarColumnas(alias string, columnas string) string {
+	partes := strings.Split(columnas, ", ")
+	for i, c := range partes {
+		partes[i] = alias + "." + c
+	}
+	return strings.Join(partes, ", ")
+}
+
+// Los comodines de LIKE que escriba el usuario se buscan tal cual
+var escaparLike = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace
@@ -0,0 +1,295 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+)
+
+const capituloColumnas = "id, manga_id, numero, titulo, fecha_publicacion, paginas_tot, origen, origen_mod, faltante"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCapitulo(row scanner) (models.Capitulo, error) {
+	var c models.Capitulo
+	err := row.Scan(
+		&c.ID,
+		&c.MangaID,
+		&c.Numero,
+		&c.Titulo,
+		&c.FechaPublicacion,
+		&c.PaginasTot,
+		&c.Origen,
+		&c.OrigenMod,
+		&c.Faltante,
+	)
+	return c, err
+}
+
+// Inserta el capítulo con sus páginas en una sola transacción: nunca
+// queda un capítulo sin páginas
+func CreateCapitulo(db *sql.DB, capitulo models.Capitulo, archivos []string) (int, error) {
+	if capitulo.FechaPublicacion.IsZero() {
+		capitulo.FechaPublicacion = time.Now()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO capitulos (manga_id, numero, titulo, fecha_publicacion, origen, origen_mod)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	res, err := tx.Exec(
+		query,
+		capitulo.MangaID,
+		capitulo.Numero,
+		capitulo.Titulo,
+		capitulo.FechaPublicacion,
+		capitulo.Origen,
+		capitulo.OrigenMod,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	err = guardarPaginas(tx, int(id), archivos)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), actualizarCapitulosTot(db, capitulo.MangaID)
+}
+
+func GetCapitulosByManga(db *sql.DB, mangaID int) ([]models.Capitulo, error) {
+	rows, err := db.Query(`
+		SELECT `+capituloColumnas+`
+		FROM capitulos
+		WHERE manga_id = ?
+		ORDER BY numero
+	`, mangaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var capitulos []models.Capitulo
+
+	for rows.Next() {
+		c, err := scanCapitulo(rows)
+		if err != nil {
+			return nil, err
+		}
+		capitulos = append(capitulos, c)
+	}
+
+	return capitulos, rows.Err()
+}
+
+// Capítulos importados desde la biblioteca, indexados por su ruta de origen
+func GetCapitulosConOrigen(db *sql.DB) (map[string]models.Capitulo, error) {
+	rows, err := db.Query(`
+		SELECT ` + capituloColumnas + `
+		FROM capitulos
+		WHERE origen != ''
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	capitulos := make(map[string]models.Capitulo)
+
+	for rows.Next() {
+		c, err := scanCapitulo(rows)
+		if err != nil {
+			return nil, err
+		}
+		capitulos[c.Origen] = c
+	}
+
+	return capitulos, rows.Err()
+}
+
+func GetCapitulo(db *sql.DB, mangaID int, numero int) (models.Capitulo, error) {
+	row := db.QueryRow(`
+		SELECT `+capituloColumnas+`
+		FROM capitulos
+		WHERE manga_id = ? AND numero = ?
+	`, mangaID, numero)
+
+	return scanCapitulo(row)
+}
+
+func MarcarCapituloFaltante(db *sql.DB, capituloID int, faltante bool) error {
+	_, err := db.Exec("UPDATE capitulos SET faltante = ? WHERE id = ?", faltante, capituloID)
+	return err
+}
+
+// Borra el capítulo y sus páginas (no toca los archivos en disco)
+func DeleteCapitulo(db *sql.DB, capitulo models.Capitulo) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM paginas WHERE capitulo_id = ?", capitulo.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM capitulos_leidos WHERE capitulo_id = ?", capitulo.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM marcadores WHERE manga_id = ? AND capitulo = ?", capitulo.MangaID, capitulo.Numero)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM capitulos WHERE id = ?", capitulo.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return actualizarCapitulosTot(db, capitulo.MangaID)
+}
+
+// Páginas de un capítulo que se volvió a importar desde la biblioteca,
+// junto con su título y la fecha de su origen. Al mantener el id se
+// conservan capitulos_leidos y marcadores
+func ReemplazarPaginas(db *sql.DB, capitulo models.Capitulo, archivos []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE capitulos
+		SET titulo = ?, origen_mod = ?, faltante = 0
+		WHERE id = ?
+	`, capitulo.Titulo, capitulo.OrigenMod, capitulo.ID)
+	if err != nil {
+		return err
+	}
+
+	err = guardarPaginas(tx, capitulo.ID, archivos)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func guardarPaginas(tx *sql.Tx, capituloID int, archivos []string) error {
+	_, err := tx.Exec("DELETE FROM paginas WHERE capitulo_id = ?", capituloID)
+	if err != nil {
+		return err
+	}
+
+	for i, archivo := range archivos {
+		_, err = tx.Exec(`
+			INSERT INTO paginas (capitulo_id, numero, archivo)
+			VALUES (?, ?, ?)
+		`, capituloID, i+1, archivo)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE capitulos SET paginas_tot = ? WHERE id = ?", len(archivos), capituloID)
+	return err
+}
+
+func GetPaginasByCapitulo(db *sql.DB, capituloID int) ([]models.Pagina, error) {
+	rows, err := db.Query(`
+		SELECT id, capitulo_id, numero, archivo
+		FROM paginas
+		WHERE capitulo_id = ?
+		ORDER BY numero
+	`, capituloID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paginas []models.Pagina
+
+	for rows.Next() {
+		var p models.Pagina
+		err := rows.Scan(&p.ID, &p.CapituloID, &p.Numero, &p.Archivo)
+		if err != nil {
+			return nil, err
+		}
+		paginas = append(paginas, p)
+	}
+
+	return paginas, rows.Err()
+}
+
+// Archivo de la primera página de cada capítulo del manga, por número de
+// capítulo. Sirve de miniatura en la lista de capítulos
+func GetPrimerasPaginas(db *sql.DB, mangaID int) (map[int]string, error) {
+	rows, err := db.Query(`
+		SELECT c.numero, p.archivo
+		FROM capitulos c
+		JOIN paginas p ON p.capitulo_id = c.id
+		WHERE c.manga_id = ? AND p.numero = (
+			SELECT MIN(numero) FROM paginas WHERE capitulo_id = c.id
+		)
+	`, mangaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	primeras := map[int]string{}
+
+	for rows.Next() {
+		var numero int
+		var archivo string
+
+		err := rows.Scan(&numero, &archivo)
+		if err != nil {
+			return nil, err
+		}
+		primeras[numero] = archivo
+	}
+
+	return primeras, rows.Err()
+}
+
+// Mantiene mangas.capitulos_tot igual al número de capítulos registrados
+func actualizarCapitulosTot(db *sql.DB, mangaID int) error {
+	_, err := db.Exec(`
+		UPDATE mangas
+		SET capitulos_tot = (SELECT COUNT(*) FROM capitulos WHERE manga_id = ?)
+		WHERE id = ?
+	`, mangaID, mangaID)
+	return err
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+)
+
+// Estado de los capítulos de un manga para el usuario, por número de
+// capítulo. Los capítulos nunca leídos no aparecen
+func GetCapitulosLeidos(db *sql.DB, usuarioID int, mangaID int) (map[int]models.CapituloLeido, error) {
+	rows, err := db.Query(`
+		SELECT c.numero, cl.capitulo_id, cl.leido, cl.veces, cl.fecha
+		FROM capitulos_leidos cl
+		JOIN capitulos c ON c.id = cl.capitulo_id
+		WHERE cl.usuario_id = ? AND c.manga_id = ?
+	`, usuarioID, mangaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leidos := map[int]models.CapituloLeido{}
+
+	for rows.Next() {
+		var numero int
+		var c models.CapituloLeido
+
+		err := rows.Scan(&numero, &c.CapituloID, &c.Leido, &c.Veces, &c.Fecha)
+		if err != nil {
+			return nil, err
+		}
+		leidos[numero] = c
+	}
+
+	return leidos, rows.Err()
+}
+
+// Marca como leídos los capítulos desde..hasta (incluidos). Volver a
+// terminar un capítulo marcado como no leído suma una vez más
+func marcarLeidos(tx *sql.Tx, usuarioID int, mangaID int, desde int, hasta int, fecha time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO capitulos_leidos (usuario_id, capitulo_id, leido, veces, fecha)
+		SELECT ?, id, 1, 1, ?
+		FROM capitulos
+		WHERE manga_id = ? AND numero BETWEEN ? AND ?
+		ON CONFLICT(usuario_id, capitulo_id) DO UPDATE
+		SET leido = 1, veces = veces + 1, fecha = excluded.fecha
+		WHERE leido = 0
+	`, usuarioID, fecha, mangaID, desde, hasta)
+	return err
+}
+
+// Marca como leídos todos los capítulos hasta numero (incluido) y avanza
+// la posición al final de ese capítulo si estaba antes
+func MarcarLeidosHasta(db *sql.DB, usuarioID int, mangaID int, numero int) error {
+	ahora := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = marcarLeidos(tx, usuarioID, mangaID, 0, numero, ahora)
+	if err != nil {
+		return err
+	}
+
+	var paginas int
+	err = tx.QueryRow(`
+		SELECT paginas_tot FROM capitulos WHERE manga_id = ? AND numero = ?
+	`, mangaID, numero).Scan(&paginas)
+	if err != nil {
+		return err
+	}
+
+	err = asegurarLectura(tx, usuarioID, mangaID, ahora)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE lecturas
+		SET capitulo_actual = ?, pagina_actual = ?, ultima_lectura = ?
+		WHERE usuario_id = ? AND manga_id = ? AND capitulo_actual <= ?
+	`, numero, paginas, ahora, usuarioID, mangaID, numero)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Marca como no leídos los capítulos desde..hasta (incluidos). Si la
+// posición estaba dentro o después del rango vuelve al inicio de desde
+func MarcarNoLeidos(db *sql.DB, usuarioID int, mangaID int, desde int, hasta int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE capitulos_leidos
+		SET leido = 0
+		WHERE usuario_id = ? AND capitulo_id IN (
+			SELECT id FROM capitulos WHERE manga_id = ? AND numero BETWEEN ? AND ?
+		)
+	`, usuarioID, mangaID, desde, hasta)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE lecturas
+		SET capitulo_actual = ?, pagina_actual = 0
+		WHERE usuario_id = ? AND manga_id = ? AND capitulo_actual >= ?
+	`, desde, usuarioID, mangaID, desde)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Vuelve a empezar la serie: todos los capítulos sin leer, posición al
+// principio y una relectura más. Las veces de cada capítulo se conservan
+func ReiniciarSerie(db *sql.DB, usuarioID int, mangaID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE capitulos_leidos
+		SET leido = 0
+		WHERE usuario_id = ? AND capitulo_id IN (SELECT id FROM capitulos WHERE manga_id = ?)
+	`, usuarioID, mangaID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE lecturas
+		SET capitulo_actual = 0, pagina_actual = 0, relecturas = relecturas + 1,
+		    estado = ?, terminada = NULL
+		WHERE usuario_id = ? AND manga_id = ?
+	`, models.EstadoLeyendo, usuarioID, mangaID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Crea la lectura del usuario si todavía no existe
+func asegurarLectura(tx *sql.Tx, usuarioID int, mangaID int, ahora time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO lecturas (usuario_id, manga_id, estado, iniciada, ultima_lectura)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(usuario_id, manga_id) DO NOTHING
+	`, usuarioID, mangaID, models.EstadoLeyendo, ahora, ahora)
+	return err
+}
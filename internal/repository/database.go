@@ -8,7 +8,9 @@ import (
 )
 
 func NewDatabase() *sql.DB {
-	db, err := sql.Open("sqlite", "./db/inkzen.db")
+	// Con busy_timeout las escrituras simultáneas esperan su turno en vez
+	// de fallar con "database is locked"
+	db, err := sql.Open("sqlite", "./db/inkzen.db?_time_format=sqlite&_pragma=busy_timeout(5000)")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -21,57 +23,14 @@ func NewDatabase() *sql.DB {
 	return db
 }
 
+// Aplica las migraciones pendientes (ver migraciones.go)
 func InitSchema(db *sql.DB) {
-
-	// Tabla usuarios
-	userTable := `
-	CREATE TABLE IF NOT EXISTS usuarios (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		nombre TEXT NOT NULL,
-		email TEXT NOT NULL UNIQUE,
-		password TEXT NOT NULL
-	);
-	`
-
-	_, err := db.Exec(userTable)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Tabla mangas (contenido digital)
-	mangaTable := `
-	CREATE TABLE IF NOT EXISTS mangas (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		titulo TEXT NOT NULL,
-		autor TEXT NOT NULL,
-		genero TEXT,
-		idioma TEXT,
-		editorial TEXT,
-		descripcion TEXT,
-		capitulos_tot INTEGER DEFAULT 0,
-		disponible BOOLEAN DEFAULT 1
-	);
-	`
-
-	_, err = db.Exec(mangaTable)
+	aplicadas, err := Migrar(db)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Tabla lecturas (relación usuario - manga)
-	lecturaTable := `
-	CREATE TABLE IF NOT EXISTS lecturas (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		usuario_id INTEGER NOT NULL,
-		manga_id INTEGER NOT NULL,
-		capitulo_actual INTEGER DEFAULT 0,
-		FOREIGN KEY(usuario_id) REFERENCES usuarios(id),
-		FOREIGN KEY(manga_id) REFERENCES mangas(id)
-	);
-	`
-
-	_, err = db.Exec(lecturaTable)
-	if err != nil {
-		log.Fatal(err)
+	for _, m := range aplicadas {
+		log.Printf("Migración aplicada: %04d_%s", m.Version, m.Nombre)
 	}
 }
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+)
+
+// Volver a abrir el mismo capítulo dentro de este margen (por ejemplo al
+// pasar páginas en el modo paginado) no añade otra entrada
+const ventanaHistorial = 30 * time.Minute
+
+type FiltroHistorial struct {
+	// 0 = todas las series
+	MangaID int
+	// Fechas cero = sin límite. Hasta no se incluye
+	Desde     time.Time
+	Hasta     time.Time
+	Pagina    int
+	PorPagina int
+}
+
+// Normaliza página y tamaño
+func (f *FiltroHistorial) Normalizar() {
+	if f.Pagina < 1 {
+		f.Pagina = 1
+	}
+	if f.PorPagina < 1 || f.PorPagina > MaxPorPagina {
+		f.PorPagina = 50
+	}
+}
+
+// Añade una entrada salvo que la última del usuario sea el mismo capítulo
+// hace menos de ventanaHistorial
+func RegistrarHistorial(db *sql.DB, entrada models.EntradaHistorial) error {
+	if entrada.Fecha.IsZero() {
+		entrada.Fecha = time.Now()
+	}
+
+	var mangaID, capitulo int
+	var fecha time.Time
+
+	err := db.QueryRow(`
+		SELECT manga_id, capitulo, fecha
+		FROM historial
+		WHERE usuario_id = ?
+		ORDER BY fecha DESC, id DESC
+		LIMIT 1
+	`, entrada.UsuarioID).Scan(&mangaID, &capitulo, &fecha)
+
+	if err == nil && mangaID == entrada.MangaID && capitulo == entrada.Capitulo &&
+		entrada.Fecha.Sub(fecha) < ventanaHistorial {
+		return nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO historial (usuario_id, manga_id, capitulo, fecha, agente)
+		VALUES (?, ?, ?, ?, ?)
+	`, entrada.UsuarioID, entrada.MangaID, entrada.Capitulo, entrada.Fecha, entrada.Agente)
+	return err
+}
+
+// Historial del usuario filtrado y paginado, lo más reciente primero.
+// Devuelve también el total de entradas que cumplen el filtro
+func ListarHistorial(db *sql.DB, usuarioID int, filtro FiltroHistorial) ([]models.EntradaHistorial, int, error) {
+	filtro.Normalizar()
+
+	where := []string{"h.usuario_id = ?"}
+	args := []interface{}{usuarioID}
+
+	if filtro.MangaID > 0 {
+		where = append(where, "h.manga_id = ?")
+		args = append(args, filtro.MangaID)
+	}
+	if !filtro.Desde.IsZero() {
+		where = append(where, "h.fecha >= ?")
+		args = append(args, filtro.Desde)
+	}
+	if !filtro.Hasta.IsZero() {
+		where = append(where, "h.fecha < ?")
+		args = append(args, filtro.Hasta)
+	}
+
+	from := " FROM historial h JOIN mangas m ON m.id = h.manga_id WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	err := db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT h.id, h.usuario_id, h.manga_id, h.capitulo, h.fecha, h.agente, m.titulo`+from+`
+		ORDER BY h.fecha DESC, h.id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, filtro.PorPagina, (filtro.Pagina-1)*filtro.PorPagina)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entradas := []models.EntradaHistorial{}
+
+	for rows.Next() {
+		var e models.EntradaHistorial
+		err := rows.Scan(&e.ID, &e.UsuarioID, &e.MangaID, &e.Capitulo, &e.Fecha, &e.Agente, &e.MangaTitulo)
+		if err != nil {
+			return nil, 0, err
+		}
+		entradas = append(entradas, e)
+	}
+
+	return entradas, total, rows.Err()
+}
+
+// Mangas que aparecen en el historial del usuario, para el filtro por serie
+func MangasEnHistorial(db *sql.DB, usuarioID int) ([]models.Manga, error) {
+	rows, err := db.Query(`
+		SELECT `+prefijarColumnas("m", mangaColumnas)+`
+		FROM mangas m
+		WHERE m.id IN (SELECT manga_id FROM historial WHERE usuario_id = ?)
+		ORDER BY m.titulo COLLATE NOCASE
+	`, usuarioID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mangas []models.Manga
+
+	for rows.Next() {
+		m, err := scanManga(rows)
+		if err != nil {
+			return nil, err
+		}
+		mangas = append(mangas, m)
+	}
+
+	return mangas, rows.Err()
+}
+
+// Borra una entrada del usuario. sql.ErrNoRows si no existe o es de otro
+func BorrarEntradaHistorial(db *sql.DB, usuarioID int, id int) error {
+	res, err := db.Exec("DELETE FROM historial WHERE id = ? AND usuario_id = ?", id, usuarioID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func BorrarHistorial(db *sql.DB, usuarioID int) error {
+	_, err := db.Exec("DELETE FROM historial WHERE usuario_id = ?", usuarioID)
+	return err
+}
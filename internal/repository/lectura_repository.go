@@ -2,32 +2,59 @@ package repository
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/Graynie/InkZen/internal/models"
 )
 
-func CrearLectura(db *sql.DB, lectura models.Lectura) error {
-	query := `
-	INSERT INTO lecturas (usuario_id, manga_id, capitulo_actual)
-	VALUES (?, ?, ?)
-	`
-	_, err := db.Exec(query, lectura.UsuarioID, lectura.MangaID, lectura.CapituloActual)
-	return err
+const lecturaColumnas = "id, usuario_id, manga_id, capitulo_actual, pagina_actual, relecturas, " +
+	"estado, puntuacion, iniciada, ultima_lectura, terminada"
+
+// extra recibe las columnas que la consulta añada al final
+func scanLectura(row scanner, extra ...interface{}) (models.Lectura, error) {
+	var l models.Lectura
+	var iniciada, ultima, terminada sql.NullTime
+
+	dest := []interface{}{
+		&l.ID,
+		&l.UsuarioID,
+		&l.MangaID,
+		&l.CapituloActual,
+		&l.PaginaActual,
+		&l.Relecturas,
+		&l.Estado,
+		&l.Puntuacion,
+		&iniciada,
+		&ultima,
+		&terminada,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+
+	l.Iniciada = iniciada.Time
+	l.UltimaLectura = ultima.Time
+	l.Terminada = terminada.Time
+
+	return l, err
 }
 
-func ActualizarCapitulo(db *sql.DB, usuarioID int, mangaID int, capitulo int) error {
-	query := `
-	UPDATE lecturas
-	SET capitulo_actual = ?
-	WHERE usuario_id = ? AND manga_id = ?
-	`
-	_, err := db.Exec(query, capitulo, usuarioID, mangaID)
+// Guarda el capítulo actual del usuario en un manga, creando la lectura
+// si no existía
+func GuardarCapituloActual(db *sql.DB, usuarioID int, mangaID int, capitulo int) error {
+	ahora := time.Now()
+
+	_, err := db.Exec(`
+		INSERT INTO lecturas (usuario_id, manga_id, capitulo_actual, estado, iniciada, ultima_lectura)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(usuario_id, manga_id) DO UPDATE
+		SET capitulo_actual = excluded.capitulo_actual, ultima_lectura = excluded.ultima_lectura
+	`, usuarioID, mangaID, capitulo, models.EstadoLeyendo, ahora, ahora)
 	return err
 }
 
 func ObtenerLecturasUsuario(db *sql.DB, usuarioID int) ([]models.Lectura, error) {
 	rows, err := db.Query(`
-		SELECT id, usuario_id, manga_id, capitulo_actual
+		SELECT `+lecturaColumnas+`
 		FROM lecturas
 		WHERE usuario_id = ?
 	`, usuarioID)
@@ -39,13 +66,135 @@ func ObtenerLecturasUsuario(db *sql.DB, usuarioID int) ([]models.Lectura, error)
 	var lecturas []models.Lectura
 
 	for rows.Next() {
-		var l models.Lectura
-		err := rows.Scan(&l.ID, &l.UsuarioID, &l.MangaID, &l.CapituloActual)
+		l, err := scanLectura(rows)
 		if err != nil {
 			return nil, err
 		}
 		lecturas = append(lecturas, l)
 	}
 
-	return lecturas, nil
+	return lecturas, rows.Err()
+}
+
+// Lecturas del usuario con su manga, las más recientes primero
+func ObtenerBiblioteca(db *sql.DB, usuarioID int) ([]models.EntradaBiblioteca, error) {
+	rows, err := db.Query(`
+		SELECT `+prefijarColumnas("l", lecturaColumnas)+`, `+prefijarColumnas("m", mangaColumnas)+`
+		FROM lecturas l
+		JOIN mangas m ON m.id = l.manga_id
+		WHERE l.usuario_id = ?
+		ORDER BY COALESCE(l.ultima_lectura, l.iniciada) DESC, m.titulo COLLATE NOCASE
+	`, usuarioID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entradas []models.EntradaBiblioteca
+
+	for rows.Next() {
+		var e models.EntradaBiblioteca
+		var genero, idioma, editorial, descripcion sql.NullString
+
+		e.Lectura, err = scanLectura(rows,
+			&e.Manga.ID, &e.Manga.Titulo, &e.Manga.Autor, &genero, &idioma, &editorial,
+			&descripcion, &e.Manga.CapitulosTot, &e.Manga.Disponible, &e.Manga.Portada)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Manga.Genero = genero.String
+		e.Manga.Idioma = idioma.String
+		e.Manga.Editorial = editorial.String
+		e.Manga.Descripcion = descripcion.String
+
+		entradas = append(entradas, e)
+	}
+
+	return entradas, rows.Err()
+}
+
+func GetLectura(db *sql.DB, usuarioID int, mangaID int) (models.Lectura, error) {
+	row := db.QueryRow(`
+		SELECT `+lecturaColumnas+`
+		FROM lecturas
+		WHERE usuario_id = ? AND manga_id = ?
+	`, usuarioID, mangaID)
+
+	return scanLectura(row)
+}
+
+// Registra la página alcanzada. La posición solo avanza (capítulo y luego
+// página) y el capítulo se marca como leído cuando se llega a su última
+// página. Leer una serie pendiente o en pausa la pasa a "leyendo"
+func GuardarProgresoPagina(db *sql.DB, usuarioID int, mangaID int, capitulo int, pagina int, terminado bool) error {
+	ahora := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if terminado {
+		err = marcarLeidos(tx, usuarioID, mangaID, capitulo, capitulo, ahora)
+		if err != nil {
+			return err
+		}
+	}
+
+	// En SET todas las columnas valen lo que tenía la fila antes del cambio
+	_, err = tx.Exec(`
+		INSERT INTO lecturas
+		(usuario_id, manga_id, capitulo_actual, pagina_actual, estado, iniciada, ultima_lectura)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(usuario_id, manga_id) DO UPDATE SET
+			capitulo_actual = MAX(capitulo_actual, excluded.capitulo_actual),
+			pagina_actual = CASE
+				WHEN excluded.capitulo_actual > capitulo_actual
+					OR (excluded.capitulo_actual = capitulo_actual AND excluded.pagina_actual > pagina_actual)
+				THEN excluded.pagina_actual
+				ELSE pagina_actual
+			END,
+			estado = CASE WHEN estado IN (?, ?) THEN excluded.estado ELSE estado END,
+			iniciada = COALESCE(iniciada, excluded.iniciada),
+			ultima_lectura = excluded.ultima_lectura
+	`, usuarioID, mangaID, capitulo, pagina, models.EstadoLeyendo, ahora, ahora,
+		models.EstadoPendiente, models.EstadoEnPausa)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Cambia el estado y la puntuación, creando la lectura si no existe (por
+// ejemplo al marcar un manga como pendiente). Completar fija la fecha de
+// fin y empezar a leer la de inicio
+func ActualizarEstadoLectura(db *sql.DB, usuarioID int, mangaID int, estado string, puntuacion int) error {
+	ahora := time.Now()
+
+	var iniciada, terminada interface{}
+	if estado != models.EstadoPendiente {
+		iniciada = ahora
+	}
+	if estado == models.EstadoCompletado {
+		terminada = ahora
+	}
+
+	// Se conserva la fecha de inicio y, si ya estaba completado, la de fin
+	_, err := db.Exec(`
+		INSERT INTO lecturas (usuario_id, manga_id, estado, puntuacion, iniciada, terminada)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(usuario_id, manga_id) DO UPDATE SET
+			estado = excluded.estado,
+			puntuacion = excluded.puntuacion,
+			iniciada = COALESCE(iniciada, excluded.iniciada),
+			terminada = CASE
+				WHEN estado = ? AND excluded.estado = ? AND terminada IS NOT NULL THEN terminada
+				ELSE excluded.terminada
+			END
+	`, usuarioID, mangaID, estado, puntuacion, iniciada, terminada,
+		models.EstadoCompletado, models.EstadoCompletado)
+	return err
 }
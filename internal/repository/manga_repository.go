@@ -2,18 +2,50 @@ package repository
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/Graynie/InkZen/internal/models"
 )
 
-func CreateManga(db *sql.DB, manga models.Manga) error {
+const mangaColumnas = "id, titulo, autor, genero, idioma, editorial, descripcion, capitulos_tot, disponible, portada"
+
+// Las columnas de texto opcionales pueden ser NULL en filas editadas a
+// mano. extra recibe las columnas que la consulta añada al final
+func scanManga(row scanner, extra ...interface{}) (models.Manga, error) {
+	var m models.Manga
+	var genero, idioma, editorial, descripcion sql.NullString
+
+	dest := []interface{}{
+		&m.ID,
+		&m.Titulo,
+		&m.Autor,
+		&genero,
+		&idioma,
+		&editorial,
+		&descripcion,
+		&m.CapitulosTot,
+		&m.Disponible,
+		&m.Portada,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+
+	m.Genero = genero.String
+	m.Idioma = idioma.String
+	m.Editorial = editorial.String
+	m.Descripcion = descripcion.String
+
+	return m, err
+}
+
+func CreateManga(db *sql.DB, manga models.Manga) (int, error) {
 	query := `
 	INSERT INTO mangas 
-	(titulo, autor, genero, idioma, editorial, descripcion, capitulos_tot, disponible)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	(titulo, autor, genero, idioma, editorial, descripcion, capitulos_tot, disponible, portada)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := db.Exec(
+	res, err := db.Exec(
 		query,
 		manga.Titulo,
 		manga.Autor,
@@ -23,14 +55,46 @@ func CreateManga(db *sql.DB, manga models.Manga) error {
 		manga.Descripcion,
 		manga.CapitulosTot,
 		manga.Disponible,
+		manga.Portada,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func UpdateManga(db *sql.DB, manga models.Manga) error {
+	query := `
+	UPDATE mangas
+	SET titulo = ?, autor = ?, genero = ?, idioma = ?, editorial = ?, descripcion = ?, disponible = ?
+	WHERE id = ?
+	`
+
+	_, err := db.Exec(
+		query,
+		manga.Titulo,
+		manga.Autor,
+		manga.Genero,
+		manga.Idioma,
+		manga.Editorial,
+		manga.Descripcion,
+		manga.Disponible,
+		manga.ID,
 	)
 
 	return err
 }
 
+func ActualizarPortada(db *sql.DB, mangaID int, portada string) error {
+	_, err := db.Exec("UPDATE mangas SET portada = ? WHERE id = ?", portada, mangaID)
+	return err
+}
+
 func GetAllMangas(db *sql.DB) ([]models.Manga, error) {
 	rows, err := db.Query(`
-		SELECT id, titulo, autor, genero, idioma, editorial, descripcion, capitulos_tot, disponible
+		SELECT ` + mangaColumnas + `
 		FROM mangas
 	`)
 	if err != nil {
@@ -41,18 +105,7 @@ func GetAllMangas(db *sql.DB) ([]models.Manga, error) {
 	var mangas []models.Manga
 
 	for rows.Next() {
-		var m models.Manga
-		err := rows.Scan(
-			&m.ID,
-			&m.Titulo,
-			&m.Autor,
-			&m.Genero,
-			&m.Idioma,
-			&m.Editorial,
-			&m.Descripcion,
-			&m.CapitulosTot,
-			&m.Disponible,
-		)
+		m, err := scanManga(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -62,3 +115,91 @@ func GetAllMangas(db *sql.DB) ([]models.Manga, error) {
 
 	return mangas, nil
 }
+
+func GetMangaByID(db *sql.DB, id int) (models.Manga, error) {
+	row := db.QueryRow(`
+		SELECT `+mangaColumnas+`
+		FROM mangas
+		WHERE id = ?
+	`, id)
+
+	return scanManga(row)
+}
+
+func GetMangaByTitulo(db *sql.DB, titulo string) (models.Manga, error) {
+	row := db.QueryRow(`
+		SELECT `+mangaColumnas+`
+		FROM mangas
+		WHERE titulo = ? COLLATE NOCASE
+	`, titulo)
+
+	return scanManga(row)
+}
+
+// Borra el manga con sus capítulos, páginas y lecturas
+func DeleteManga(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 🔹 Si venía de la biblioteca, su carpeta queda anotada para que el
+	// escáner no la vuelva a crear
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO series_borradas (carpeta, borrada)
+		SELECT DISTINCT substr(origen, 1, instr(origen, '/') - 1), ?
+		FROM capitulos
+		WHERE manga_id = ? AND instr(origen, '/') > 1
+	`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	queries := []string{
+		"DELETE FROM paginas WHERE capitulo_id IN (SELECT id FROM capitulos WHERE manga_id = ?)",
+		"DELETE FROM capitulos_leidos WHERE capitulo_id IN (SELECT id FROM capitulos WHERE manga_id = ?)",
+		"DELETE FROM capitulos WHERE manga_id = ?",
+		"DELETE FROM lecturas WHERE manga_id = ?",
+		"DELETE FROM historial WHERE manga_id = ?",
+		"DELETE FROM marcadores WHERE manga_id = ?",
+		"DELETE FROM mangas WHERE id = ?",
+	}
+
+	for _, query := range queries {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Carpetas de la biblioteca que el escáner debe saltarse
+func GetSeriesBorradas(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query("SELECT carpeta FROM series_borradas")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	carpetas := make(map[string]bool)
+
+	for rows.Next() {
+		var carpeta string
+		err := rows.Scan(&carpeta)
+		if err != nil {
+			return nil, err
+		}
+		carpetas[carpeta] = true
+	}
+
+	return carpetas, rows.Err()
+}
+
+// La carpeta ya no está en disco: si vuelve, se importa de nuevo
+func OlvidarSerieBorrada(db *sql.DB, carpeta string) error {
+	_, err := db.Exec("DELETE FROM series_borradas WHERE carpeta = ?", carpeta)
+	return err
+}
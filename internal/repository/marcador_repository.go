@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+)
+
+const marcadorColumnas = "id, usuario_id, manga_id, capitulo, pagina, nota, creado"
+
+func scanMarcador(row scanner) (models.Marcador, error) {
+	var m models.Marcador
+	err := row.Scan(&m.ID, &m.UsuarioID, &m.MangaID, &m.Capitulo, &m.Pagina, &m.Nota, &m.Creado)
+	return m, err
+}
+
+// Crea el marcador; si la página ya estaba marcada solo cambia la nota
+func CrearMarcador(db *sql.DB, marcador models.Marcador) (models.Marcador, error) {
+	_, err := db.Exec(`
+		INSERT INTO marcadores (usuario_id, manga_id, capitulo, pagina, nota, creado)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(usuario_id, manga_id, capitulo, pagina) DO UPDATE
+		SET nota = excluded.nota
+	`, marcador.UsuarioID, marcador.MangaID, marcador.Capitulo, marcador.Pagina, marcador.Nota, time.Now())
+	if err != nil {
+		return marcador, err
+	}
+
+	row := db.QueryRow(`
+		SELECT `+marcadorColumnas+`
+		FROM marcadores
+		WHERE usuario_id = ? AND manga_id = ? AND capitulo = ? AND pagina = ?
+	`, marcador.UsuarioID, marcador.MangaID, marcador.Capitulo, marcador.Pagina)
+
+	return scanMarcador(row)
+}
+
+// Marcador del usuario. sql.ErrNoRows si no existe o es de otro
+func GetMarcador(db *sql.DB, usuarioID int, id int) (models.Marcador, error) {
+	row := db.QueryRow(`
+		SELECT `+marcadorColumnas+`
+		FROM marcadores
+		WHERE id = ? AND usuario_id = ?
+	`, id, usuarioID)
+
+	return scanMarcador(row)
+}
+
+// Marcadores del usuario en orden de lectura; mangaID 0 = todos
+func GetMarcadores(db *sql.DB, usuarioID int, mangaID int) ([]models.Marcador, error) {
+	query := `
+		SELECT ` + marcadorColumnas + `
+		FROM marcadores
+		WHERE usuario_id = ?`
+	args := []interface{}{usuarioID}
+
+	if mangaID > 0 {
+		query += " AND manga_id = ?"
+		args = append(args, mangaID)
+	}
+
+	rows, err := db.Query(query+" ORDER BY manga_id, capitulo, pagina", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	marcadores := []models.Marcador{}
+
+	for rows.Next() {
+		m, err := scanMarcador(rows)
+		if err != nil {
+			return nil, err
+		}
+		marcadores = append(marcadores, m)
+	}
+
+	return marcadores, rows.Err()
+}
+
+// sql.ErrNoRows si no existe o es de otro
+func ActualizarNotaMarcador(db *sql.DB, usuarioID int, id int, nota string) error {
+	res, err := db.Exec("UPDATE marcadores SET nota = ? WHERE id = ? AND usuario_id = ?", nota, id, usuarioID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// sql.ErrNoRows si no existe o es de otro
+func BorrarMarcador(db *sql.DB, usuarioID int, id int) error {
+	res, err := db.Exec("DELETE FROM marcadores WHERE id = ? AND usuario_id = ?", id, usuarioID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
@@ -0,0 +1,285 @@
+package repository
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migraciones versionadas: migraciones/<version>_<nombre>.up.sql y su
+// .down.sql. Las aplicadas se guardan en schema_migrations
+//
+//go:embed migraciones/*.sql
+var migracionesFS embed.FS
+
+type Migracion struct {
+	Version  int
+	Nombre   string
+	Up       string
+	Down     string
+	Aplicada time.Time
+}
+
+func (m Migracion) EstaAplicada() bool {
+	return !m.Aplicada.IsZero()
+}
+
+func crearTablaMigraciones(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		nombre TEXT NOT NULL,
+		aplicada DATETIME NOT NULL
+	);
+	`)
+	return err
+}
+
+// Lee las migraciones embebidas ordenadas por versión
+func cargarMigraciones() ([]Migracion, error) {
+	archivos, err := migracionesFS.ReadDir("migraciones")
+	if err != nil {
+		return nil, err
+	}
+
+	porVersion := make(map[int]*Migracion)
+
+	for _, archivo := range archivos {
+		nombre := archivo.Name()
+
+		var direccion string
+		switch {
+		case strings.HasSuffix(nombre, ".up.sql"):
+			direccion = "up"
+		case strings.HasSuffix(nombre, ".down.sql"):
+			direccion = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(nombre, "."+direccion+".sql")
+		partes := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(partes[0])
+		if err != nil || len(partes) != 2 {
+			return nil, fmt.Errorf("nombre de migración inválido: %s", nombre)
+		}
+
+		contenido, err := migracionesFS.ReadFile(path.Join("migraciones", nombre))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := porVersion[version]
+		if !ok {
+			m = &Migracion{Version: version, Nombre: partes[1]}
+			porVersion[version] = m
+		}
+
+		if direccion == "up" {
+			m.Up = string(contenido)
+		} else {
+			m.Down = string(contenido)
+		}
+	}
+
+	var migraciones []Migracion
+	for _, m := range porVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("la migración %d no tiene .up.sql", m.Version)
+		}
+		migraciones = append(migraciones, *m)
+	}
+
+	sort.Slice(migraciones, func(i, j int) bool {
+		return migraciones[i].Version < migraciones[j].Version
+	})
+
+	return migraciones, nil
+}
+
+// Todas las migraciones conocidas, con la fecha en que se aplicaron
+func EstadoMigraciones(db *sql.DB) ([]Migracion, error) {
+	err := crearTablaMigraciones(db)
+	if err != nil {
+		return nil, err
+	}
+
+	migraciones, err := cargarMigraciones()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, aplicada FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aplicadas := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var aplicada time.Time
+		if err := rows.Scan(&version, &aplicada); err != nil {
+			return nil, err
+		}
+		aplicadas[version] = aplicada
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range migraciones {
+		migraciones[i].Aplicada = aplicadas[migraciones[i].Version]
+	}
+
+	return migraciones, nil
+}
+
+// Aplica en orden las migraciones pendientes. Devuelve las aplicadas
+func Migrar(db *sql.DB) ([]Migracion, error) {
+	// Una base creada por el antiguo InitSchema ya tiene parte del
+	// esquema: se adopta ignorando las columnas que ya existen
+	adopcion := !tablaExiste(db, "schema_migrations") && tablaExiste(db, "usuarios")
+
+	migraciones, err := EstadoMigraciones(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var aplicadas []Migracion
+
+	for _, m := range migraciones {
+		if m.EstaAplicada() {
+			continue
+		}
+
+		err := ejecutarMigracion(db, m, m.Up, adopcion, func(tx *sql.Tx) error {
+			_, err := tx.Exec(
+				"INSERT INTO schema_migrations (version, nombre, aplicada) VALUES (?, ?, ?)",
+				m.Version, m.Nombre, time.Now(),
+			)
+			return err
+		})
+		if err != nil {
+			return aplicadas, err
+		}
+
+		aplicadas = append(aplicadas, m)
+	}
+
+	return aplicadas, nil
+}
+
+// Revierte, de la más nueva a la más vieja, las migraciones aplicadas con
+// versión mayor que hasta. Devuelve las revertidas
+func RevertirMigraciones(db *sql.DB, hasta int) ([]Migracion, error) {
+	migraciones, err := EstadoMigraciones(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var revertidas []Migracion
+
+	for i := len(migraciones) - 1; i >= 0; i-- {
+		m := migraciones[i]
+		if m.Version <= hasta || !m.EstaAplicada() {
+			continue
+		}
+
+		if m.Down == "" {
+			return revertidas, fmt.Errorf("la migración %d no tiene .down.sql", m.Version)
+		}
+
+		err := ejecutarMigracion(db, m, m.Down, false, func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+			return err
+		})
+		if err != nil {
+			return revertidas, err
+		}
+
+		revertidas = append(revertidas, m)
+	}
+
+	return revertidas, nil
+}
+
+// Ejecuta el SQL y el registro en schema_migrations en una sola transacción
+func ejecutarMigracion(db *sql.DB, m Migracion, script string, adopcion bool, registrar func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, sentencia := range dividirSentencias(script) {
+		_, err = tx.Exec(sentencia)
+		if err != nil {
+			if adopcion && strings.Contains(err.Error(), "duplicate column name") {
+				continue
+			}
+			return fmt.Errorf("migración %04d_%s: %w", m.Version, m.Nombre, err)
+		}
+	}
+
+	err = registrar(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Separa un script en sentencias por el ";" final de línea, sin cortar
+// los cuerpos BEGIN ... END; de los triggers
+func dividirSentencias(script string) []string {
+	var sentencias []string
+	var actual strings.Builder
+	enTrigger := false
+
+	for _, linea := range strings.Split(script, "\n") {
+		limpia := strings.ToUpper(strings.TrimSpace(linea))
+
+		if strings.HasPrefix(limpia, "--") && actual.Len() == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(limpia, "CREATE TRIGGER") {
+			enTrigger = true
+		}
+
+		actual.WriteString(linea)
+		actual.WriteString("\n")
+
+		if !strings.HasSuffix(limpia, ";") {
+			continue
+		}
+
+		if enTrigger && limpia != "END;" {
+			continue
+		}
+
+		sentencias = append(sentencias, strings.TrimSpace(actual.String()))
+		actual.Reset()
+		enTrigger = false
+	}
+
+	if resto := strings.TrimSpace(actual.String()); resto != "" {
+		sentencias = append(sentencias, resto)
+	}
+
+	return sentencias
+}
+
+func tablaExiste(db *sql.DB, tabla string) bool {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", tabla,
+	).Scan(&n)
+	return err == nil && n > 0
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS lecturas;
+DROP TABLE IF EXISTS mangas;
+DROP TABLE IF EXISTS usuarios;
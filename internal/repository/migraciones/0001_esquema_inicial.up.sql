@@ -0,0 +1,33 @@
+-- Esquema original de InitSchema. IF NOT EXISTS permite adoptar las bases
+-- creadas antes de existir las migraciones
+
+-- Tabla usuarios
+CREATE TABLE IF NOT EXISTS usuarios (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	nombre TEXT NOT NULL,
+	email TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL
+);
+
+-- Tabla mangas (contenido digital)
+CREATE TABLE IF NOT EXISTS mangas (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	titulo TEXT NOT NULL,
+	autor TEXT NOT NULL,
+	genero TEXT,
+	idioma TEXT,
+	editorial TEXT,
+	descripcion TEXT,
+	capitulos_tot INTEGER DEFAULT 0,
+	disponible BOOLEAN DEFAULT 1
+);
+
+-- Tabla lecturas (relación usuario - manga)
+CREATE TABLE IF NOT EXISTS lecturas (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	usuario_id INTEGER NOT NULL,
+	manga_id INTEGER NOT NULL,
+	capitulo_actual INTEGER DEFAULT 0,
+	FOREIGN KEY(usuario_id) REFERENCES usuarios(id),
+	FOREIGN KEY(manga_id) REFERENCES mangas(id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS paginas;
+DROP TABLE IF EXISTS capitulos;
@@ -0,0 +1,21 @@
+-- Tabla capitulos (pertenecen a un manga)
+CREATE TABLE IF NOT EXISTS capitulos (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	manga_id INTEGER NOT NULL,
+	numero INTEGER NOT NULL,
+	titulo TEXT NOT NULL DEFAULT '',
+	fecha_publicacion DATETIME DEFAULT CURRENT_TIMESTAMP,
+	paginas_tot INTEGER DEFAULT 0,
+	UNIQUE(manga_id, numero),
+	FOREIGN KEY(manga_id) REFERENCES mangas(id)
+);
+
+-- Tabla paginas (imágenes ordenadas de cada capítulo)
+CREATE TABLE IF NOT EXISTS paginas (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	capitulo_id INTEGER NOT NULL,
+	numero INTEGER NOT NULL,
+	archivo TEXT NOT NULL,
+	UNIQUE(capitulo_id, numero),
+	FOREIGN KEY(capitulo_id) REFERENCES capitulos(id)
+);
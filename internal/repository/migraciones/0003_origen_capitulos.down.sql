@@ -0,0 +1,3 @@
+ALTER TABLE capitulos DROP COLUMN faltante;
+ALTER TABLE capitulos DROP COLUMN origen_mod;
+ALTER TABLE capitulos DROP COLUMN origen;
@@ -0,0 +1,4 @@
+-- Columnas del escáner de biblioteca
+ALTER TABLE capitulos ADD COLUMN origen TEXT NOT NULL DEFAULT '';
+ALTER TABLE capitulos ADD COLUMN origen_mod INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE capitulos ADD COLUMN faltante BOOLEAN NOT NULL DEFAULT 0;
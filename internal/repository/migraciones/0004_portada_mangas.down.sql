@@ -0,0 +1 @@
+ALTER TABLE mangas DROP COLUMN portada;
@@ -0,0 +1 @@
+ALTER TABLE mangas ADD COLUMN portada TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE usuarios ADD COLUMN rol TEXT NOT NULL DEFAULT 'lector';
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS capitulos_fts_delete;
+DROP TRIGGER IF EXISTS capitulos_fts_update;
+DROP TRIGGER IF EXISTS capitulos_fts_insert;
+DROP TRIGGER IF EXISTS mangas_fts_delete;
+DROP TRIGGER IF EXISTS mangas_fts_update;
+DROP TRIGGER IF EXISTS mangas_fts_insert;
+DROP TABLE IF EXISTS mangas_fts;
@@ -0,0 +1,61 @@
+-- Índice de búsqueda de texto completo del catálogo (rowid = mangas.id).
+-- remove_diacritics hace que "kimetsu" encuentre "Kimétsu"
+CREATE VIRTUAL TABLE mangas_fts USING fts5(
+	titulo,
+	autor,
+	genero,
+	editorial,
+	descripcion,
+	capitulos,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO mangas_fts (rowid, titulo, autor, genero, editorial, descripcion, capitulos)
+SELECT
+	m.id,
+	m.titulo,
+	m.autor,
+	coalesce(m.genero, ''),
+	coalesce(m.editorial, ''),
+	coalesce(m.descripcion, ''),
+	coalesce((SELECT group_concat(c.titulo, ' ') FROM capitulos c WHERE c.manga_id = m.id), '')
+FROM mangas m;
+
+-- Sincronización con mangas
+CREATE TRIGGER mangas_fts_insert AFTER INSERT ON mangas BEGIN
+	INSERT INTO mangas_fts (rowid, titulo, autor, genero, editorial, descripcion, capitulos)
+	VALUES (new.id, new.titulo, new.autor, coalesce(new.genero, ''), coalesce(new.editorial, ''), coalesce(new.descripcion, ''), '');
+END;
+
+CREATE TRIGGER mangas_fts_update AFTER UPDATE OF titulo, autor, genero, editorial, descripcion ON mangas BEGIN
+	UPDATE mangas_fts
+	SET titulo = new.titulo,
+		autor = new.autor,
+		genero = coalesce(new.genero, ''),
+		editorial = coalesce(new.editorial, ''),
+		descripcion = coalesce(new.descripcion, '')
+	WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER mangas_fts_delete AFTER DELETE ON mangas BEGIN
+	DELETE FROM mangas_fts WHERE rowid = old.id;
+END;
+
+-- Sincronización con los títulos de capítulos
+CREATE TRIGGER capitulos_fts_insert AFTER INSERT ON capitulos BEGIN
+	UPDATE mangas_fts
+	SET capitulos = coalesce((SELECT group_concat(titulo, ' ') FROM capitulos WHERE manga_id = new.manga_id), '')
+	WHERE rowid = new.manga_id;
+END;
+
+CREATE TRIGGER capitulos_fts_update AFTER UPDATE OF titulo ON capitulos BEGIN
+	UPDATE mangas_fts
+	SET capitulos = coalesce((SELECT group_concat(titulo, ' ') FROM capitulos WHERE manga_id = new.manga_id), '')
+	WHERE rowid = new.manga_id;
+END;
+
+CREATE TRIGGER capitulos_fts_delete AFTER DELETE ON capitulos BEGIN
+	UPDATE mangas_fts
+	SET capitulos = coalesce((SELECT group_concat(titulo, ' ') FROM capitulos WHERE manga_id = old.manga_id), '')
+	WHERE rowid = old.manga_id;
+END;
@@ -0,0 +1,2 @@
+-- Sentido de lectura del modo por páginas: rtl (manga) o ltr
+ALTER TABLE usuarios ADD COLUMN direccion_lectura TEXT NOT NULL DEFAULT 'rtl';
@@ -0,0 +1,2 @@
+ALTER TABLE lecturas DROP COLUMN capitulo_leido;
+ALTER TABLE lecturas DROP COLUMN pagina_actual;
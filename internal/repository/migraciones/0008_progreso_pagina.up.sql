@@ -0,0 +1,7 @@
+-- Página alcanzada dentro de capitulo_actual y último capítulo terminado
+-- (leído hasta su última página)
+ALTER TABLE lecturas ADD COLUMN pagina_actual INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE lecturas ADD COLUMN capitulo_leido INTEGER NOT NULL DEFAULT 0;
+
+-- Antes se daban por leídos los capítulos anteriores al actual
+UPDATE lecturas SET capitulo_leido = capitulo_actual - 1 WHERE capitulo_actual > 0;
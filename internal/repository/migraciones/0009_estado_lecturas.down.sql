@@ -0,0 +1,5 @@
+ALTER TABLE lecturas DROP COLUMN puntuacion;
+ALTER TABLE lecturas DROP COLUMN terminada;
+ALTER TABLE lecturas DROP COLUMN ultima_lectura;
+ALTER TABLE lecturas DROP COLUMN iniciada;
+ALTER TABLE lecturas DROP COLUMN estado;
@@ -0,0 +1,6 @@
+-- Estantería de cada lectura: estado, fechas y puntuación personal (1-10)
+ALTER TABLE lecturas ADD COLUMN estado TEXT NOT NULL DEFAULT 'leyendo';
+ALTER TABLE lecturas ADD COLUMN iniciada DATETIME;
+ALTER TABLE lecturas ADD COLUMN ultima_lectura DATETIME;
+ALTER TABLE lecturas ADD COLUMN terminada DATETIME;
+ALTER TABLE lecturas ADD COLUMN puntuacion INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_historial_usuario_fecha;
+DROP TABLE IF EXISTS historial;
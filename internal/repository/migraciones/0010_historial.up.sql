@@ -0,0 +1,13 @@
+-- Historial de lecturas: una fila por capítulo abierto, solo se añade
+CREATE TABLE historial (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	usuario_id INTEGER NOT NULL,
+	manga_id INTEGER NOT NULL,
+	capitulo INTEGER NOT NULL,
+	fecha DATETIME NOT NULL,
+	agente TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(usuario_id) REFERENCES usuarios(id),
+	FOREIGN KEY(manga_id) REFERENCES mangas(id)
+);
+
+CREATE INDEX idx_historial_usuario_fecha ON historial(usuario_id, fecha);
@@ -0,0 +1,12 @@
+ALTER TABLE lecturas ADD COLUMN capitulo_leido INTEGER NOT NULL DEFAULT 0;
+
+UPDATE lecturas SET capitulo_leido = COALESCE((
+	SELECT MAX(c.numero)
+	FROM capitulos_leidos cl
+	JOIN capitulos c ON c.id = cl.capitulo_id
+	WHERE cl.usuario_id = lecturas.usuario_id AND c.manga_id = lecturas.manga_id AND cl.leido = 1
+), 0);
+
+ALTER TABLE lecturas DROP COLUMN relecturas;
+
+DROP TABLE IF EXISTS capitulos_leidos;
@@ -0,0 +1,22 @@
+-- Estado de lectura de cada capítulo por usuario. Marcar como no leído
+-- conserva la fila para no perder veces (cuántas veces se terminó)
+CREATE TABLE capitulos_leidos (
+	usuario_id INTEGER NOT NULL,
+	capitulo_id INTEGER NOT NULL,
+	leido BOOLEAN NOT NULL DEFAULT 1,
+	veces INTEGER NOT NULL DEFAULT 1,
+	fecha DATETIME NOT NULL,
+	PRIMARY KEY(usuario_id, capitulo_id),
+	FOREIGN KEY(usuario_id) REFERENCES usuarios(id),
+	FOREIGN KEY(capitulo_id) REFERENCES capitulos(id)
+);
+
+INSERT INTO capitulos_leidos (usuario_id, capitulo_id, fecha)
+SELECT l.usuario_id, c.id, CURRENT_TIMESTAMP
+FROM lecturas l
+JOIN capitulos c ON c.manga_id = l.manga_id AND c.numero <= l.capitulo_leido;
+
+-- Veces que el usuario ha vuelto a empezar la serie
+ALTER TABLE lecturas ADD COLUMN relecturas INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE lecturas DROP COLUMN capitulo_leido;
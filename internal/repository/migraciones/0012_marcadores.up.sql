@@ -0,0 +1,13 @@
+-- Marcadores personales en una página concreta, con nota opcional
+CREATE TABLE marcadores (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	usuario_id INTEGER NOT NULL,
+	manga_id INTEGER NOT NULL,
+	capitulo INTEGER NOT NULL,
+	pagina INTEGER NOT NULL,
+	nota TEXT NOT NULL DEFAULT '',
+	creado DATETIME NOT NULL,
+	UNIQUE(usuario_id, manga_id, capitulo, pagina),
+	FOREIGN KEY(usuario_id) REFERENCES usuarios(id),
+	FOREIGN KEY(manga_id) REFERENCES mangas(id)
+);
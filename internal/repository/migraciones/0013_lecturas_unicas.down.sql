@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_lecturas_usuario_manga;
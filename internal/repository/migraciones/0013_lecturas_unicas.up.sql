@@ -0,0 +1,51 @@
+-- Una sola lectura por usuario y manga. Los duplicados se funden en el
+-- más avanzado (y, a igualdad, el más reciente): se queda con la mejor
+-- puntuación, las relecturas, la primera fecha de inicio y la última de
+-- lectura, y con el estado y la fecha de fin del que se tocó por último
+UPDATE lecturas SET
+	relecturas = (SELECT MAX(o.relecturas) FROM lecturas o WHERE o.usuario_id = lecturas.usuario_id AND o.manga_id = lecturas.manga_id),
+	puntuacion = (SELECT MAX(o.puntuacion) FROM lecturas o WHERE o.usuario_id = lecturas.usuario_id AND o.manga_id = lecturas.manga_id),
+	iniciada = (SELECT MIN(o.iniciada) FROM lecturas o WHERE o.usuario_id = lecturas.usuario_id AND o.manga_id = lecturas.manga_id),
+	ultima_lectura = (SELECT MAX(o.ultima_lectura) FROM lecturas o WHERE o.usuario_id = lecturas.usuario_id AND o.manga_id = lecturas.manga_id),
+	estado = (
+		SELECT o.estado FROM lecturas o
+		WHERE o.usuario_id = lecturas.usuario_id AND o.manga_id = lecturas.manga_id
+		ORDER BY MAX(COALESCE(o.ultima_lectura, ''), COALESCE(o.terminada, ''), COALESCE(o.iniciada, '')) DESC, o.id DESC
+		LIMIT 1
+	),
+	terminada = (
+		SELECT o.terminada FROM lecturas o
+		WHERE o.usuario_id = lecturas.usuario_id AND o.manga_id = lecturas.manga_id
+		ORDER BY MAX(COALESCE(o.ultima_lectura, ''), COALESCE(o.terminada, ''), COALESCE(o.iniciada, '')) DESC, o.id DESC
+		LIMIT 1
+	)
+WHERE EXISTS (
+	SELECT 1 FROM lecturas o
+	WHERE o.usuario_id = lecturas.usuario_id
+	AND o.manga_id = lecturas.manga_id
+	AND o.id != lecturas.id
+)
+AND NOT EXISTS (
+	SELECT 1 FROM lecturas o
+	WHERE o.usuario_id = lecturas.usuario_id
+	AND o.manga_id = lecturas.manga_id
+	AND (
+		o.capitulo_actual > lecturas.capitulo_actual
+		OR (o.capitulo_actual = lecturas.capitulo_actual AND o.pagina_actual > lecturas.pagina_actual)
+		OR (o.capitulo_actual = lecturas.capitulo_actual AND o.pagina_actual = lecturas.pagina_actual AND o.id > lecturas.id)
+	)
+);
+
+DELETE FROM lecturas
+WHERE EXISTS (
+	SELECT 1 FROM lecturas o
+	WHERE o.usuario_id = lecturas.usuario_id
+	AND o.manga_id = lecturas.manga_id
+	AND (
+		o.capitulo_actual > lecturas.capitulo_actual
+		OR (o.capitulo_actual = lecturas.capitulo_actual AND o.pagina_actual > lecturas.pagina_actual)
+		OR (o.capitulo_actual = lecturas.capitulo_actual AND o.pagina_actual = lecturas.pagina_actual AND o.id > lecturas.id)
+	)
+);
+
+CREATE UNIQUE INDEX idx_lecturas_usuario_manga ON lecturas(usuario_id, manga_id);
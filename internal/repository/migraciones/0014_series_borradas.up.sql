@@ -0,0 +1,6 @@
+-- Carpetas de la biblioteca cuya serie borró un administrador:
+-- el escáner no las vuelve a importar mientras sigan en disco
+CREATE TABLE series_borradas (
+	carpeta TEXT PRIMARY KEY,
+	borrada DATETIME NOT NULL
+);
@@ -8,24 +8,87 @@ import (
 
 func CreateUser(db *sql.DB, user models.Usuario) error {
 	query := `
-	INSERT INTO usuarios (nombre, email, password)
-	VALUES (?, ?, ?);
+	INSERT INTO usuarios (nombre, email, password, rol)
+	VALUES (?, ?, ?, ?);
 	`
 
-	_, err := db.Exec(query, user.Nombre, user.Email, user.Password)
+	if user.Rol == "" {
+		user.Rol = models.RolLector
+	}
+
+	_, err := db.Exec(query, user.Nombre, user.Email, user.Password, user.Rol)
 	return err
 }
+
 func GetUserByEmail(db *sql.DB, email string) (models.Usuario, error) {
 	var user models.Usuario
 
-	query := "SELECT id, nombre, email, password FROM usuarios WHERE email = ?"
+	query := "SELECT id, nombre, email, password, rol FROM usuarios WHERE email = ?"
 
 	err := db.QueryRow(query, email).Scan(
 		&user.ID,
 		&user.Nombre,
 		&user.Email,
 		&user.Password,
+		&user.Rol,
 	)
 
 	return user, err
 }
+
+const usuarioColumnas = "id, nombre, email, rol, direccion_lectura"
+
+// Sin la contraseña
+func scanUsuario(row scanner) (models.Usuario, error) {
+	var u models.Usuario
+	err := row.Scan(&u.ID, &u.Nombre, &u.Email, &u.Rol, &u.DireccionLectura)
+	return u, err
+}
+
+func GetUsuario(db *sql.DB, id int) (models.Usuario, error) {
+	return scanUsuario(db.QueryRow("SELECT "+usuarioColumnas+" FROM usuarios WHERE id = ?", id))
+}
+
+func GetUsuarios(db *sql.DB) ([]models.Usuario, error) {
+	rows, err := db.Query("SELECT " + usuarioColumnas + " FROM usuarios ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usuarios []models.Usuario
+
+	for rows.Next() {
+		u, err := scanUsuario(rows)
+		if err != nil {
+			return nil, err
+		}
+		usuarios = append(usuarios, u)
+	}
+
+	return usuarios, rows.Err()
+}
+
+func ActualizarRol(db *sql.DB, usuarioID int, rol string) error {
+	res, err := db.Exec("UPDATE usuarios SET rol = ? WHERE id = ?", rol, usuarioID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+func GetDireccionLectura(db *sql.DB, usuarioID int) (string, error) {
+	var direccion string
+	err := db.QueryRow("SELECT direccion_lectura FROM usuarios WHERE id = ?", usuarioID).Scan(&direccion)
+	return direccion, err
+}
+
+func ActualizarDireccionLectura(db *sql.DB, usuarioID int, direccion string) error {
+	_, err := db.Exec("UPDATE usuarios SET direccion_lectura = ? WHERE id = ?", direccion, usuarioID)
+	return err
+}
@@ -0,0 +1,490 @@
+package services
+
+import (
+	"archive/zip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+)
+
+const (
+	// Tamaño máximo del archivo .cbz/.zip subido
+	MaxArchivoCapitulo = 200 << 20
+	// Tamaño máximo descomprimido (protege contra zip bombs)
+	maxDescomprimido = 1 << 30
+	maxPaginas       = 2000
+)
+
+var (
+	ErrArchivoInvalido = errors.New("archivo no es un zip válido")
+	ErrRutaInsegura    = errors.New("el archivo contiene rutas inseguras")
+	ErrNoEsImagen      = errors.New("el archivo contiene entradas que no son imágenes")
+	ErrSinPaginas      = errors.New("el archivo no contiene imágenes")
+	ErrArchivoGrande   = errors.New("el archivo es demasiado grande")
+	ErrCapituloExiste  = errors.New("el capítulo ya existe")
+	ErrNumeroCapitulo  = errors.New("número de capítulo inválido")
+)
+
+var extensionesImagen = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+}
+
+// Página pendiente de copiar al almacenamiento del capítulo
+type fuentePagina struct {
+	nombre string
+	abrir  func() (io.ReadCloser, error)
+}
+
+// Importa un .cbz/.zip como capítulo: valida las entradas, ordena las
+// páginas por orden natural y las copia a la carpeta del capítulo.
+// Si trae ComicInfo.xml se usan sus datos para los campos vacíos
+func ImportarArchivoCapitulo(db *sql.DB, capitulo models.Capitulo, archivo io.ReaderAt, size int64) (models.Capitulo, error) {
+	fuentes, info, err := fuentesDelArchivo(archivo, size)
+	if err != nil {
+		return capitulo, err
+	}
+
+	return guardarCapitulo(db, capitulo, fuentes, info)
+}
+
+// Importa una carpeta de imágenes como capítulo, igual que un .cbz
+func ImportarCarpetaCapitulo(db *sql.DB, capitulo models.Capitulo, carpeta string) (models.Capitulo, error) {
+	fuentes, info, err := fuentesDeCarpeta(carpeta)
+	if err != nil {
+		return capitulo, err
+	}
+
+	return guardarCapitulo(db, capitulo, fuentes, info)
+}
+
+// Vuelve a importar un capítulo existente desde un .cbz/.zip que cambió.
+// Conserva el id del capítulo y con él lo que cada usuario ha leído y sus
+// marcadores; si algo falla el capítulo queda como estaba
+func ReimportarArchivoCapitulo(db *sql.DB, capitulo models.Capitulo, archivo io.ReaderAt, size int64) (models.Capitulo, error) {
+	fuentes, info, err := fuentesDelArchivo(archivo, size)
+	if err != nil {
+		return capitulo, err
+	}
+
+	return reemplazarCapitulo(db, capitulo, fuentes, info)
+}
+
+// Como ReimportarArchivoCapitulo, desde una carpeta de imágenes
+func ReimportarCarpetaCapitulo(db *sql.DB, capitulo models.Capitulo, carpeta string) (models.Capitulo, error) {
+	fuentes, info, err := fuentesDeCarpeta(carpeta)
+	if err != nil {
+		return capitulo, err
+	}
+
+	return reemplazarCapitulo(db, capitulo, fuentes, info)
+}
+
+func fuentesDelArchivo(archivo io.ReaderAt, size int64) ([]fuentePagina, *ComicInfo, error) {
+	if size > MaxArchivoCapitulo {
+		return nil, nil, ErrArchivoGrande
+	}
+
+	zr, err := zip.NewReader(archivo, size)
+	if err != nil {
+		return nil, nil, ErrArchivoInvalido
+	}
+
+	fuentes, err := paginasDelArchivo(zr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := leerComicInfo(zr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fuentes, info, nil
+}
+
+func fuentesDeCarpeta(carpeta string) ([]fuentePagina, *ComicInfo, error) {
+	fuentes, err := paginasDeCarpeta(carpeta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var info *ComicInfo
+	if f, err := os.Open(filepath.Join(carpeta, "ComicInfo.xml")); err == nil {
+		info = decodificarComicInfo(f)
+		f.Close()
+	}
+
+	return fuentes, info, nil
+}
+
+func guardarCapitulo(db *sql.DB, capitulo models.Capitulo, fuentes []fuentePagina, info *ComicInfo) (models.Capitulo, error) {
+	if info != nil {
+		info.CompletarCapitulo(&capitulo)
+	}
+
+	if capitulo.Numero <= 0 {
+		return capitulo, ErrNumeroCapitulo
+	}
+
+	_, err := repository.GetCapitulo(db, capitulo.MangaID, capitulo.Numero)
+	if err == nil {
+		return capitulo, ErrCapituloExiste
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return capitulo, err
+	}
+
+	dir := CapituloDir(capitulo.MangaID, capitulo.Numero)
+	destino := filepath.Join(StaticDir, dir)
+
+	if _, err := os.Stat(destino); err == nil {
+		return capitulo, ErrCapituloExiste
+	}
+
+	// 🔹 Las páginas se copian a una carpeta temporal que el escaneo no
+	// toma por un capítulo y que solo pasa a su sitio tras el INSERT
+	err = os.MkdirAll(filepath.Dir(destino), 0755)
+	if err != nil {
+		return capitulo, err
+	}
+
+	temporal, err := os.MkdirTemp(filepath.Dir(destino), fmt.Sprintf(".%d.subida-", capitulo.Numero))
+	if err != nil {
+		return capitulo, err
+	}
+	defer os.RemoveAll(temporal)
+
+	// MkdirTemp la crea como 0700 y /static/ tiene que poder leerla
+	err = os.Chmod(temporal, 0755)
+	if err != nil {
+		return capitulo, err
+	}
+
+	archivos, err := copiarPaginas(fuentes, temporal, dir)
+	if err != nil {
+		return capitulo, err
+	}
+
+	capitulo.ID, err = repository.CreateCapitulo(db, capitulo, archivos)
+	if err != nil {
+		return capitulo, err
+	}
+
+	err = os.Rename(temporal, destino)
+	if err != nil {
+		repository.DeleteCapitulo(db, capitulo)
+		return capitulo, err
+	}
+	capitulo.PaginasTot = len(archivos)
+
+	return capitulo, completarManga(db, capitulo.MangaID, info)
+}
+
+// Las páginas nuevas se copian a una carpeta aparte y solo ocupan el
+// lugar de las anteriores cuando están todas. El número identifica al
+// capítulo, así que de ComicInfo.xml solo se toma el título
+func reemplazarCapitulo(db *sql.DB, capitulo models.Capitulo, fuentes []fuentePagina, info *ComicInfo) (models.Capitulo, error) {
+	capitulo.Titulo = ""
+	if info != nil {
+		info.CompletarCapitulo(&capitulo)
+	}
+
+	dir := CapituloDir(capitulo.MangaID, capitulo.Numero)
+	destino := filepath.Join(StaticDir, dir)
+	nuevo := destino + ".nuevo"
+	anterior := destino + ".anterior"
+
+	// Restos de un intento anterior que no terminó
+	os.RemoveAll(nuevo)
+	os.RemoveAll(anterior)
+
+	archivos, err := copiarPaginas(fuentes, nuevo, dir)
+	if err != nil {
+		return capitulo, err
+	}
+
+	err = os.Rename(destino, anterior)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		os.RemoveAll(nuevo)
+		return capitulo, err
+	}
+
+	err = os.Rename(nuevo, destino)
+	if err == nil {
+		err = repository.ReemplazarPaginas(db, capitulo, archivos)
+	}
+	if err != nil {
+		os.RemoveAll(nuevo)
+		os.RemoveAll(destino)
+		os.Rename(anterior, destino)
+		return capitulo, err
+	}
+
+	os.RemoveAll(anterior)
+	capitulo.PaginasTot = len(archivos)
+	capitulo.Faltante = false
+
+	return capitulo, completarManga(db, capitulo.MangaID, info)
+}
+
+// Copia las páginas a destino como 001.ext, 002.ext... y devuelve sus
+// rutas dentro de dir. Si falla no deja nada en destino
+func copiarPaginas(fuentes []fuentePagina, destino string, dir string) ([]string, error) {
+	err := os.MkdirAll(destino, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	var archivos []string
+	for i, f := range fuentes {
+		nombre := fmt.Sprintf("%03d%s", i+1, strings.ToLower(path.Ext(f.nombre)))
+
+		err = copiarPagina(f, filepath.Join(destino, nombre))
+		if err != nil {
+			os.RemoveAll(destino)
+			return nil, err
+		}
+
+		archivos = append(archivos, dir+"/"+nombre)
+	}
+
+	return archivos, nil
+}
+
+// Asigna portada si no tiene y completa el manga con ComicInfo.xml
+func completarManga(db *sql.DB, mangaID int, info *ComicInfo) error {
+	err := AsegurarPortada(db, mangaID)
+	if err != nil {
+		return err
+	}
+
+	if info == nil {
+		return nil
+	}
+
+	manga, err := repository.GetMangaByID(db, mangaID)
+	if err != nil {
+		return err
+	}
+
+	if info.CompletarManga(&manga) {
+		return repository.UpdateManga(db, manga)
+	}
+
+	return nil
+}
+
+// Devuelve las imágenes del zip en orden de lectura
+func paginasDelArchivo(zr *zip.Reader) ([]fuentePagina, error) {
+	var fuentes []fuentePagina
+	var total uint64
+
+	for _, f := range zr.File {
+		if !rutaSegura(f.Name) {
+			return nil, ErrRutaInsegura
+		}
+
+		if f.FileInfo().IsDir() || ignorarEntrada(f.Name) {
+			continue
+		}
+
+		fuente := fuentePagina{nombre: f.Name, abrir: f.Open}
+
+		err := validarImagen(fuente)
+		if err != nil {
+			return nil, err
+		}
+
+		total += f.UncompressedSize64
+		if total > maxDescomprimido || len(fuentes) >= maxPaginas {
+			return nil, ErrArchivoGrande
+		}
+
+		fuentes = append(fuentes, fuente)
+	}
+
+	return ordenarPaginas(fuentes)
+}
+
+// Devuelve las imágenes de una carpeta en orden de lectura
+func paginasDeCarpeta(carpeta string) ([]fuentePagina, error) {
+	files, err := os.ReadDir(carpeta)
+	if err != nil {
+		return nil, err
+	}
+
+	var fuentes []fuentePagina
+
+	for _, file := range files {
+		if file.IsDir() || ignorarEntrada(file.Name()) {
+			continue
+		}
+
+		ruta := filepath.Join(carpeta, file.Name())
+		fuente := fuentePagina{
+			nombre: file.Name(),
+			abrir: func() (io.ReadCloser, error) {
+				return os.Open(ruta)
+			},
+		}
+
+		err := validarImagen(fuente)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fuentes) >= maxPaginas {
+			return nil, ErrArchivoGrande
+		}
+
+		fuentes = append(fuentes, fuente)
+	}
+
+	return ordenarPaginas(fuentes)
+}
+
+func ordenarPaginas(fuentes []fuentePagina) ([]fuentePagina, error) {
+	if len(fuentes) == 0 {
+		return nil, ErrSinPaginas
+	}
+
+	sort.SliceStable(fuentes, func(i, j int) bool {
+		return NaturalLess(fuentes[i].nombre, fuentes[j].nombre)
+	})
+
+	return fuentes, nil
+}
+
+// Rechaza rutas absolutas o que salen de la carpeta (zip-slip)
+func rutaSegura(nombre string) bool {
+	nombre = strings.ReplaceAll(nombre, "\\", "/")
+
+	if nombre == "" || strings.HasPrefix(nombre, "/") || strings.Contains(nombre, ":") {
+		return false
+	}
+
+	for _, parte := range strings.Split(nombre, "/") {
+		if parte == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Metadatos y basura de sistemas operativos que no son páginas
+func ignorarEntrada(nombre string) bool {
+	base := path.Base(nombre)
+
+	return strings.HasPrefix(nombre, "__MACOSX/") ||
+		strings.HasPrefix(base, ".") ||
+		strings.EqualFold(base, "Thumbs.db") ||
+		strings.EqualFold(base, "ComicInfo.xml")
+}
+
+// Exige extensión de imagen y contenido que lo confirme
+func validarImagen(f fuentePagina) error {
+	if !extensionesImagen[strings.ToLower(path.Ext(f.nombre))] {
+		return ErrNoEsImagen
+	}
+
+	rc, err := f.abrir()
+	if err != nil {
+		return ErrArchivoInvalido
+	}
+	defer rc.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(rc, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return ErrArchivoInvalido
+	}
+
+	if !strings.HasPrefix(http.DetectContentType(buf[:n]), "image/") {
+		return ErrNoEsImagen
+	}
+
+	return nil
+}
+
+func copiarPagina(f fuentePagina, destino string) error {
+	rc, err := f.abrir()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.Create(destino)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, rc)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Compara nombres de archivo tratando los grupos de dígitos como números,
+// de forma que "2.jpg" va antes que "10.jpg"
+func NaturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+
+	for a != "" && b != "" {
+		ca, cb := a[0], b[0]
+
+		if esDigito(ca) && esDigito(cb) {
+			na, restoA := cortarNumero(a)
+			nb, restoB := cortarNumero(b)
+
+			if na != nb {
+				if len(na) != len(nb) {
+					return len(na) < len(nb)
+				}
+				return na < nb
+			}
+
+			a, b = restoA, restoB
+			continue
+		}
+
+		if ca != cb {
+			return ca < cb
+		}
+
+		a, b = a[1:], b[1:]
+	}
+
+	return len(a) < len(b)
+}
+
+func esDigito(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Separa el número inicial (sin ceros a la izquierda) del resto
+func cortarNumero(s string) (string, string) {
+	i := 0
+	for i < len(s) && esDigito(s[i]) {
+		i++
+	}
+
+	num := strings.TrimLeft(s[:i], "0")
+	return num, s[i:]
+}
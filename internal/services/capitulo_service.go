@@ -0,0 +1,110 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+)
+
+// Raíz servida en /static/
+const StaticDir = "web/static"
+
+// Ruta (relativa a StaticDir) de la carpeta de un capítulo
+func CapituloDir(mangaID int, numero int) string {
+	return fmt.Sprintf("uploads/%d/capitulos/%d", mangaID, numero)
+}
+
+// Registra en la base de datos los capítulos que solo existen como carpetas
+// en web/static/uploads/<manga>/capitulos/<n>/ y asigna portada a los
+// mangas que todavía no tienen
+func ImportarCapitulosExistentes(db *sql.DB) error {
+	mangas, err := repository.GetAllMangas(db)
+	if err != nil {
+		return err
+	}
+
+	for _, manga := range mangas {
+		dir := filepath.Join(StaticDir, fmt.Sprintf("uploads/%d/capitulos", manga.ID))
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, file := range files {
+			if !file.IsDir() {
+				continue
+			}
+
+			numero, err := strconv.Atoi(file.Name())
+			if err != nil {
+				continue
+			}
+
+			_, err = repository.GetCapitulo(db, manga.ID, numero)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+
+			err = registrarCapitulo(db, manga.ID, numero)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, manga := range mangas {
+		err = AsegurarPortada(db, manga.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func registrarCapitulo(db *sql.DB, mangaID int, numero int) error {
+	dir := CapituloDir(mangaID, numero)
+
+	files, err := os.ReadDir(filepath.Join(StaticDir, dir))
+	if err != nil {
+		return err
+	}
+
+	var archivos []string
+	for _, file := range files {
+		if !file.IsDir() {
+			archivos = append(archivos, dir+"/"+file.Name())
+		}
+	}
+
+	sort.Slice(archivos, func(i, j int) bool {
+		return NaturalLess(archivos[i], archivos[j])
+	})
+
+	_, err = repository.CreateCapitulo(db, models.Capitulo{
+		MangaID: mangaID,
+		Numero:  numero,
+	}, archivos)
+	return err
+}
+
+// Borra el capítulo de la base de datos y sus imágenes del disco
+func EliminarCapitulo(db *sql.DB, capitulo models.Capitulo) error {
+	err := repository.DeleteCapitulo(db, capitulo)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(filepath.Join(StaticDir, CapituloDir(capitulo.MangaID, capitulo.Numero)))
+}
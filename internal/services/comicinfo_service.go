@@ -0,0 +1,153 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Graynie/InkZen/internal/models"
+)
+
+// Metadatos de ComicInfo.xml (esquema de ComicRack) que usamos
+type ComicInfo struct {
+	XMLName     xml.Name `xml:"ComicInfo"`
+	Series      string   `xml:"Series,omitempty"`
+	Number      string   `xml:"Number,omitempty"`
+	Title       string   `xml:"Title,omitempty"`
+	Writer      string   `xml:"Writer,omitempty"`
+	Publisher   string   `xml:"Publisher,omitempty"`
+	Genre       string   `xml:"Genre,omitempty"`
+	LanguageISO string   `xml:"LanguageISO,omitempty"`
+	Summary     string   `xml:"Summary,omitempty"`
+
+	// Solo se escriben al exportar
+	Count     int    `xml:"Count,omitempty"`
+	Year      int    `xml:"Year,omitempty"`
+	Month     int    `xml:"Month,omitempty"`
+	Day       int    `xml:"Day,omitempty"`
+	PageCount int    `xml:"PageCount,omitempty"`
+	Manga     string `xml:"Manga,omitempty"`
+}
+
+// Lee ComicInfo.xml de un .cbz/.zip. Devuelve nil si el archivo no lo trae
+func LeerComicInfo(archivo io.ReaderAt, size int64) (*ComicInfo, error) {
+	zr, err := zip.NewReader(archivo, size)
+	if err != nil {
+		return nil, ErrArchivoInvalido
+	}
+
+	return leerComicInfo(zr)
+}
+
+func leerComicInfo(zr *zip.Reader) (*ComicInfo, error) {
+	for _, f := range zr.File {
+		if !strings.EqualFold(f.Name, "ComicInfo.xml") {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, ErrArchivoInvalido
+		}
+		defer rc.Close()
+
+		return decodificarComicInfo(rc), nil
+	}
+
+	return nil, nil
+}
+
+// Un ComicInfo.xml roto no impide importar las páginas: devuelve nil
+func decodificarComicInfo(r io.Reader) *ComicInfo {
+	var info ComicInfo
+
+	err := xml.NewDecoder(io.LimitReader(r, 1<<20)).Decode(&info)
+	if err != nil {
+		return nil
+	}
+
+	return &info
+}
+
+// ComicInfo de un capítulo para incluirlo en su .cbz. direccion es el
+// sentido de lectura (models.DireccionRTL o DireccionLTR); si no se conoce
+// se declara manga sin sentido de lectura
+func NuevoComicInfo(manga models.Manga, capitulo models.Capitulo, direccion string) ComicInfo {
+	// mangas.idioma es texto libre ("Español"); ComicInfo pide el código
+	var idioma string
+	if manga.Idioma != "" {
+		idioma = CodigoIdioma(manga.Idioma)
+	}
+
+	info := ComicInfo{
+		Series:      manga.Titulo,
+		Number:      strconv.Itoa(capitulo.Numero),
+		Title:       capitulo.Titulo,
+		Writer:      manga.Autor,
+		Publisher:   manga.Editorial,
+		Genre:       manga.Genero,
+		LanguageISO: idioma,
+		Summary:     manga.Descripcion,
+		Count:       manga.CapitulosTot,
+		PageCount:   capitulo.PaginasTot,
+		Manga:       "Yes",
+	}
+
+	if direccion == models.DireccionRTL {
+		info.Manga = "YesAndRightToLeft"
+	}
+
+	if !capitulo.FechaPublicacion.IsZero() {
+		info.Year = capitulo.FechaPublicacion.Year()
+		info.Month = int(capitulo.FechaPublicacion.Month())
+		info.Day = capitulo.FechaPublicacion.Day()
+	}
+
+	return info
+}
+
+// Número de capítulo; solo se aceptan números enteros positivos
+func (c *ComicInfo) NumeroCapitulo() (int, bool) {
+	numero, err := strconv.Atoi(strings.TrimSpace(c.Number))
+	if err != nil || numero <= 0 {
+		return 0, false
+	}
+	return numero, true
+}
+
+// Rellena los campos vacíos del manga. Devuelve true si cambió alguno
+func (c *ComicInfo) CompletarManga(manga *models.Manga) bool {
+	cambios := false
+
+	completar := func(campo *string, valor string) {
+		valor = strings.TrimSpace(valor)
+		if *campo == "" && valor != "" {
+			*campo = valor
+			cambios = true
+		}
+	}
+
+	completar(&manga.Titulo, c.Series)
+	completar(&manga.Autor, c.Writer)
+	completar(&manga.Editorial, c.Publisher)
+	completar(&manga.Genero, c.Genre)
+	completar(&manga.Idioma, NombreIdioma(c.LanguageISO))
+	completar(&manga.Descripcion, c.Summary)
+
+	return cambios
+}
+
+// Rellena número y título del capítulo si el usuario los dejó vacíos
+func (c *ComicInfo) CompletarCapitulo(capitulo *models.Capitulo) {
+	if capitulo.Numero <= 0 {
+		if numero, ok := c.NumeroCapitulo(); ok {
+			capitulo.Numero = numero
+		}
+	}
+
+	if capitulo.Titulo == "" {
+		capitulo.Titulo = strings.TrimSpace(c.Title)
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+)
+
+// Nombre de archivo seguro para Content-Disposition y entradas del zip
+func NombreDescarga(manga models.Manga, sufijo string) string {
+	nombre := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(manga.Titulo))
+
+	if nombre == "" {
+		nombre = fmt.Sprintf("manga-%d", manga.ID)
+	}
+
+	return nombre + sufijo
+}
+
+func NombreCBZ(manga models.Manga, capitulo models.Capitulo) string {
+	return NombreDescarga(manga, fmt.Sprintf(" - Cap %03d.cbz", capitulo.Numero))
+}
+
+// Escribe el capítulo como .cbz en w: páginas en orden de lectura como
+// 001.jpg, 002.jpg... y un ComicInfo.xml con los datos del manga. Las
+// imágenes ya van comprimidas, así que se guardan sin comprimir.
+// direccion es el sentido de lectura que se declara (ver NuevoComicInfo)
+func EscribirCBZ(db *sql.DB, w io.Writer, manga models.Manga, capitulo models.Capitulo, direccion string) error {
+	paginas, err := repository.GetPaginasByCapitulo(db, capitulo.ID)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	for i, pagina := range paginas {
+		nombre := fmt.Sprintf("%03d%s", i+1, strings.ToLower(path.Ext(pagina.Archivo)))
+
+		err = copiarAlZip(zw, nombre, filepath.Join(StaticDir, filepath.FromSlash(pagina.Archivo)))
+		if err != nil {
+			return err
+		}
+	}
+
+	info := NuevoComicInfo(manga, capitulo, direccion)
+	info.PageCount = len(paginas)
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "ComicInfo.xml",
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(fw, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(fw)
+	enc.Indent("", "  ")
+	err = enc.Encode(info)
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// Escribe en w un zip con un .cbz por capítulo. Cada .cbz se genera
+// directamente dentro de su entrada, sin pasar por memoria ni por disco
+func EscribirSerieZip(db *sql.DB, w io.Writer, manga models.Manga, capitulos []models.Capitulo, direccion string) error {
+	zw := zip.NewWriter(w)
+
+	for _, capitulo := range capitulos {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     NombreCBZ(manga, capitulo),
+			Method:   zip.Store,
+			Modified: capitulo.FechaPublicacion,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = EscribirCBZ(db, fw, manga, capitulo, direccion)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func copiarAlZip(zw *zip.Writer, nombre string, ruta string) error {
+	f, err := os.Open(ruta)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := &zip.FileHeader{
+		Name:     nombre,
+		Method:   zip.Store,
+		Modified: info.ModTime(),
+	}
+
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, f)
+	return err
+}
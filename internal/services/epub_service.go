@@ -0,0 +1,428 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+	"golang.org/x/image/draw"
+)
+
+// Ajustes para lectores de tinta electrónica
+type OpcionesEPUB struct {
+	// Convierte las páginas a escala de grises
+	Gris bool
+	// Reduce las páginas más anchas que esto; 0 = tamaño original
+	AnchoMax int
+	// Sentido de lectura (models.DireccionRTL o DireccionLTR)
+	Direccion string
+}
+
+// Anchos ofrecidos en la exportación, según la pantalla del lector
+var AnchosEPUB = []int{758, 1072, 1264, 1448}
+
+// Tipos de imagen que todos los lectores EPUB 3 muestran. El resto (WebP)
+// se convierte a JPEG
+var tiposImagen = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+}
+
+// Códigos BCP 47 de los idiomas que se suelen escribir en mangas.idioma
+var codigosIdioma = map[string]string{
+	"español":  "es",
+	"espanol":  "es",
+	"inglés":   "en",
+	"ingles":   "en",
+	"english":  "en",
+	"japonés":  "ja",
+	"japones":  "ja",
+	"japanese": "ja",
+}
+
+// Nombre de cada código de codigosIdioma, tal como se escribe en mangas.idioma
+var nombresIdioma = map[string]string{
+	"es": "Español",
+	"en": "Inglés",
+	"ja": "Japonés",
+}
+
+// Elemento del manifiesto del paquete
+type itemEPUB struct {
+	id          string
+	href        string
+	tipo        string
+	propiedades string
+}
+
+// Página ya escrita en el zip
+type paginaEPUB struct {
+	xhtml string
+	ancho int
+	alto  int
+}
+
+type escritorEPUB struct {
+	zw       *zip.Writer
+	opciones OpcionesEPUB
+	items    []itemEPUB
+	spine    []string
+}
+
+// Escribe en w un EPUB3 de maquetación fija con los capítulos indicados:
+// portada, una página por imagen y un índice con el inicio de cada capítulo
+func EscribirEPUB(db *sql.DB, w io.Writer, manga models.Manga, capitulos []models.Capitulo, opciones OpcionesEPUB) error {
+	e := &escritorEPUB{zw: zip.NewWriter(w), opciones: opciones}
+
+	err := e.escribirMimetype()
+	if err != nil {
+		return err
+	}
+
+	err = e.escribir("META-INF/container.xml", zip.Deflate, []byte(xml.Header+
+		`<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`))
+	if err != nil {
+		return err
+	}
+
+	var indice []string
+	var primera paginaEPUB
+
+	for _, capitulo := range capitulos {
+		paginas, err := repository.GetPaginasByCapitulo(db, capitulo.ID)
+		if err != nil {
+			return err
+		}
+
+		for i, pagina := range paginas {
+			id := fmt.Sprintf("c%04d-p%04d", capitulo.Numero, i+1)
+
+			p, err := e.pagina(id, filepath.Join(StaticDir, filepath.FromSlash(pagina.Archivo)), "")
+			if err != nil {
+				return err
+			}
+
+			if primera.xhtml == "" {
+				primera = p
+			}
+
+			if i == 0 {
+				titulo := fmt.Sprintf("Capítulo %d", capitulo.Numero)
+				if capitulo.Titulo != "" {
+					titulo += ": " + capitulo.Titulo
+				}
+				indice = append(indice, fmt.Sprintf(`      <li><a href="%s">%s</a></li>`, p.xhtml, escaparXML(titulo)))
+			}
+		}
+	}
+
+	// La portada es la del manga o, si no tiene, la primera página
+	ruta, err := RutaImagen(manga.Portada)
+	if err == nil {
+		err = e.portada(ruta, paginaEPUB{})
+	} else if primera.xhtml != "" {
+		err = e.portada("", primera)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = e.escribirNav(manga, indice)
+	if err != nil {
+		return err
+	}
+
+	err = e.escribirOPF(manga, capitulos)
+	if err != nil {
+		return err
+	}
+
+	return e.zw.Close()
+}
+
+func (e *escritorEPUB) escribir(nombre string, metodo uint16, datos []byte) error {
+	fw, err := e.zw.CreateHeader(&zip.FileHeader{
+		Name:     nombre,
+		Method:   metodo,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.Write(datos)
+	return err
+}
+
+// mimetype tiene que ser la primera entrada, sin comprimir y sin campos
+// extra, para que el tipo quede en el byte 38 del archivo (OCF). Por eso
+// se escribe en crudo, sin fecha ni descriptor de datos
+func (e *escritorEPUB) escribirMimetype() error {
+	datos := []byte("application/epub+zip")
+
+	fw, err := e.zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(datos),
+		CompressedSize64:   uint64(len(datos)),
+		UncompressedSize64: uint64(len(datos)),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.Write(datos)
+	return err
+}
+
+// Añade la imagen y su página XHTML al libro. propiedades marca la
+// imagen en el manifiesto (cover-image para la portada)
+func (e *escritorEPUB) pagina(id string, ruta string, propiedades string) (paginaEPUB, error) {
+	img, ext, ancho, alto, err := e.imagen(ruta)
+	if err != nil {
+		return paginaEPUB{}, err
+	}
+
+	href := "imagenes/" + id + ext
+
+	err = e.escribir("OEBPS/"+href, zip.Store, img)
+	if err != nil {
+		return paginaEPUB{}, err
+	}
+
+	p := paginaEPUB{xhtml: "paginas/" + id + ".xhtml", ancho: ancho, alto: alto}
+
+	err = e.escribir("OEBPS/"+p.xhtml, zip.Deflate, []byte(fmt.Sprintf(xml.Header+
+		`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>%s</title>
+  <meta name="viewport" content="width=%d, height=%d"/>
+  <style>html, body { margin: 0; padding: 0; } img { display: block; width: 100%%; height: 100%%; }</style>
+</head>
+<body>
+  <img src="../%s" alt=""/>
+</body>
+</html>
+`, id, ancho, alto, href)))
+	if err != nil {
+		return paginaEPUB{}, err
+	}
+
+	e.items = append(e.items,
+		itemEPUB{id: "img-" + id, href: href, tipo: tiposImagen[ext], propiedades: propiedades},
+		itemEPUB{id: id, href: p.xhtml, tipo: "application/xhtml+xml"},
+	)
+	e.spine = append(e.spine, id)
+
+	return p, nil
+}
+
+// Portada al principio del spine. Si la imagen ya es una página del libro
+// solo se marca como cover-image
+func (e *escritorEPUB) portada(ruta string, yaIncluida paginaEPUB) error {
+	if yaIncluida.xhtml != "" {
+		id := strings.TrimSuffix(path.Base(yaIncluida.xhtml), ".xhtml")
+		for i := range e.items {
+			if e.items[i].id == "img-"+id {
+				e.items[i].propiedades = "cover-image"
+			}
+		}
+		return nil
+	}
+
+	_, err := e.pagina("portada", ruta, "cover-image")
+	if err != nil {
+		return err
+	}
+
+	// pagina la añadió al final del spine; la portada va primero
+	e.spine = append([]string{"portada"}, e.spine[:len(e.spine)-1]...)
+	return nil
+}
+
+// Devuelve los bytes de la imagen, su extensión y sus dimensiones,
+// aplicando las opciones de tinta electrónica si las hay
+func (e *escritorEPUB) imagen(ruta string) ([]byte, string, int, int, error) {
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(datos))
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(ruta))
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+
+	reducir := e.opciones.AnchoMax > 0 && cfg.Width > e.opciones.AnchoMax
+	convertir := tiposImagen[ext] == ""
+	if !reducir && !e.opciones.Gris && !convertir {
+		return datos, ext, cfg.Width, cfg.Height, nil
+	}
+
+	if cfg.Width*cfg.Height > maxPixeles {
+		return nil, "", 0, 0, ErrArchivoGrande
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(datos))
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+
+	if reducir {
+		img = escalar(img, e.opciones.AnchoMax)
+	}
+
+	if e.opciones.Gris {
+		gris := image.NewGray(img.Bounds())
+		draw.Draw(gris, gris.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = gris
+	}
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: calidadJPEG})
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+
+	b := img.Bounds()
+	return buf.Bytes(), ".jpg", b.Dx(), b.Dy(), nil
+}
+
+func (e *escritorEPUB) escribirNav(manga models.Manga, indice []string) error {
+	e.items = append(e.items, itemEPUB{id: "nav", href: "nav.xhtml", tipo: "application/xhtml+xml", propiedades: "nav"})
+
+	return e.escribir("OEBPS/nav.xhtml", zip.Deflate, []byte(fmt.Sprintf(xml.Header+
+		`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>%s</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>%s</h1>
+    <ol>
+%s
+    </ol>
+  </nav>
+</body>
+</html>
+`, escaparXML(manga.Titulo), escaparXML(manga.Titulo), strings.Join(indice, "\n"))))
+}
+
+func (e *escritorEPUB) escribirOPF(manga models.Manga, capitulos []models.Capitulo) error {
+	desde, hasta := capitulos[0].Numero, capitulos[len(capitulos)-1].Numero
+
+	titulo := fmt.Sprintf("%s - Capítulo %d", manga.Titulo, desde)
+	if hasta != desde {
+		titulo = fmt.Sprintf("%s - Capítulos %d-%d", manga.Titulo, desde, hasta)
+	}
+
+	var b strings.Builder
+
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id" xml:lang="%s">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">urn:inkzen:manga:%d:%d-%d</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>%s</dc:language>
+`, CodigoIdioma(manga.Idioma), manga.ID, desde, hasta, escaparXML(titulo), CodigoIdioma(manga.Idioma))
+
+	opcionales := []struct{ elemento, valor string }{
+		{"dc:creator", manga.Autor},
+		{"dc:publisher", manga.Editorial},
+		{"dc:description", manga.Descripcion},
+	}
+	for _, genero := range strings.Split(manga.Genero, ",") {
+		opcionales = append(opcionales, struct{ elemento, valor string }{"dc:subject", genero})
+	}
+	for _, o := range opcionales {
+		if v := strings.TrimSpace(o.valor); v != "" {
+			fmt.Fprintf(&b, "    <%s>%s</%s>\n", o.elemento, escaparXML(v), o.elemento)
+		}
+	}
+
+	fmt.Fprintf(&b, `    <meta property="dcterms:modified">%s</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">portrait</meta>
+    <meta property="rendition:spread">none</meta>
+  </metadata>
+  <manifest>
+`, time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+
+	for _, item := range e.items {
+		propiedades := ""
+		if item.propiedades != "" {
+			propiedades = fmt.Sprintf(` properties="%s"`, item.propiedades)
+		}
+		fmt.Fprintf(&b, `    <item id="%s" href="%s" media-type="%s"%s/>`+"\n", item.id, item.href, item.tipo, propiedades)
+	}
+
+	direccion := models.DireccionRTL
+	if e.opciones.Direccion == models.DireccionLTR {
+		direccion = models.DireccionLTR
+	}
+
+	fmt.Fprintf(&b, "  </manifest>\n  <spine page-progression-direction=\"%s\">\n", direccion)
+	for _, id := range e.spine {
+		fmt.Fprintf(&b, `    <itemref idref="%s"/>`+"\n", id)
+	}
+	b.WriteString("  </spine>\n</package>\n")
+
+	return e.escribir("OEBPS/content.opf", zip.Deflate, []byte(b.String()))
+}
+
+// Código BCP 47 del idioma de un manga; "und" si no se reconoce
+func CodigoIdioma(idioma string) string {
+	idioma = strings.ToLower(strings.TrimSpace(idioma))
+
+	if codigo, ok := codigosIdioma[idioma]; ok {
+		return codigo
+	}
+	if len(idioma) == 2 || len(idioma) == 3 {
+		return idioma
+	}
+	return "und"
+}
+
+// Nombre con el que se guarda en mangas.idioma un idioma que llega como
+// código (ComicInfo.xml) o ya como nombre. Si no se reconoce queda tal cual
+func NombreIdioma(idioma string) string {
+	idioma = strings.TrimSpace(idioma)
+
+	if nombre, ok := nombresIdioma[CodigoIdioma(idioma)]; ok {
+		return nombre
+	}
+	return idioma
+}
+
+func escaparXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+)
+
+// El contenedor OCF exige que "mimetype" vaya primero, sin comprimir y sin
+// campos extra: nombre en los bytes 30-38 y tipo en los 38-58
+func TestEPUBMimetypeEnCrudo(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for _, dir := range []string{"db", filepath.Join(StaticDir, "uploads")} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := repository.NewDatabase()
+	defer db.Close()
+
+	_, err := repository.Migrar(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(StaticDir, "uploads", "001.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, image.NewGray(image.Rect(0, 0, 4, 6)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manga := models.Manga{Titulo: "Prueba", Disponible: true}
+	manga.ID, err = repository.CreateManga(db, manga)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capitulo := models.Capitulo{MangaID: manga.ID, Numero: 1}
+	capitulo.ID, err = repository.CreateCapitulo(db, capitulo, []string{"uploads/001.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	err = EscribirEPUB(db, &buf, manga, []models.Capitulo{capitulo}, OpcionesEPUB{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if len(b) < 58 {
+		t.Fatalf("archivo demasiado corto: %d bytes", len(b))
+	}
+
+	if got := string(b[30:58]); got != "mimetypeapplication/epub+zip" {
+		t.Errorf("bytes 30-58 = %q", got)
+	}
+
+	// Sin descriptor de datos (bit 3), sin comprimir y sin campo extra
+	if flags := int(b[6]) | int(b[7])<<8; flags&0x8 != 0 {
+		t.Errorf("flags = %#x, con descriptor de datos", flags)
+	}
+	if metodo := int(b[8]) | int(b[9])<<8; metodo != 0 {
+		t.Errorf("método = %d, quiero 0 (Store)", metodo)
+	}
+	if extra := int(b[28]) | int(b[29])<<8; extra != 0 {
+		t.Errorf("campo extra de %d bytes", extra)
+	}
+}
@@ -0,0 +1,329 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Graynie/InkZen/internal/models"
+	"github.com/Graynie/InkZen/internal/repository"
+)
+
+// Sincroniza una carpeta de biblioteca con el catálogo:
+//
+//	<raiz>/<Título de la serie>/<capítulo>/     carpeta de imágenes
+//	<raiz>/<Título de la serie>/<capítulo>.cbz  archivo .cbz/.zip
+//
+// Las páginas se copian al almacenamiento de web/static/uploads igual que
+// una subida por web, y cada capítulo recuerda su ruta de origen
+type Escaner struct {
+	db   *sql.DB
+	raiz string
+	mu   sync.Mutex
+}
+
+type ResultadoEscaneo struct {
+	MangasNuevos          int
+	CapitulosNuevos       int
+	CapitulosActualizados int
+	CapitulosFaltantes    int
+	Errores               []string
+}
+
+func NewEscaner(db *sql.DB, raiz string) *Escaner {
+	return &Escaner{db: db, raiz: raiz}
+}
+
+// Escanea ahora y luego cada intervalo, en segundo plano
+func (e *Escaner) Iniciar(intervalo time.Duration) {
+	go func() {
+		e.escanearYRegistrar()
+
+		if intervalo <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(intervalo)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			e.escanearYRegistrar()
+		}
+	}()
+}
+
+func (e *Escaner) escanearYRegistrar() {
+	res, err := e.Escanear()
+	if err != nil {
+		log.Println("Error escaneando biblioteca:", err)
+		return
+	}
+
+	for _, msg := range res.Errores {
+		log.Println("Escáner:", msg)
+	}
+
+	if res.MangasNuevos+res.CapitulosNuevos+res.CapitulosActualizados+res.CapitulosFaltantes > 0 {
+		log.Printf("Escáner: %d mangas nuevos, %d capítulos nuevos, %d actualizados, %d faltantes",
+			res.MangasNuevos, res.CapitulosNuevos, res.CapitulosActualizados, res.CapitulosFaltantes)
+	}
+}
+
+// Recorre la biblioteca una vez. Nunca corren dos escaneos a la vez
+func (e *Escaner) Escanear() (ResultadoEscaneo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var res ResultadoEscaneo
+
+	err := ImportarCapitulosExistentes(e.db)
+	if err != nil {
+		return res, err
+	}
+
+	existentes, err := repository.GetCapitulosConOrigen(e.db)
+	if err != nil {
+		return res, err
+	}
+
+	vistos := make(map[string]bool)
+
+	series, err := os.ReadDir(e.raiz)
+	if err != nil && !os.IsNotExist(err) {
+		return res, err
+	}
+	raizLeida := err == nil
+
+	borradas, err := repository.GetSeriesBorradas(e.db)
+	if err != nil {
+		return res, err
+	}
+
+	enDisco := make(map[string]bool)
+
+	for _, serie := range series {
+		if !serie.IsDir() || strings.HasPrefix(serie.Name(), ".") {
+			continue
+		}
+
+		enDisco[serie.Name()] = true
+
+		// 🔹 Un administrador borró esta serie: no se vuelve a crear
+		if borradas[serie.Name()] {
+			continue
+		}
+
+		err := e.escanearSerie(serie.Name(), existentes, vistos, &res)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	// Sin la raíz no se sabe qué carpetas siguen ahí
+	if raizLeida {
+		for carpeta := range borradas {
+			if enDisco[carpeta] {
+				continue
+			}
+
+			err = repository.OlvidarSerieBorrada(e.db, carpeta)
+			if err != nil {
+				return res, err
+			}
+		}
+	}
+
+	err = e.marcarFaltantes(existentes, vistos, &res)
+	return res, err
+}
+
+func (e *Escaner) escanearSerie(titulo string, existentes map[string]models.Capitulo, vistos map[string]bool, res *ResultadoEscaneo) error {
+	entradas, err := os.ReadDir(filepath.Join(e.raiz, titulo))
+	if err != nil {
+		return err
+	}
+
+	manga, err := repository.GetMangaByTitulo(e.db, titulo)
+	if errors.Is(err, sql.ErrNoRows) {
+		manga = models.Manga{Titulo: titulo, Disponible: true}
+		manga.ID, err = repository.CreateManga(e.db, manga)
+		if err != nil {
+			return err
+		}
+		res.MangasNuevos++
+	} else if err != nil {
+		return err
+	}
+
+	for _, entrada := range entradas {
+		nombre := entrada.Name()
+		esArchivo := !entrada.IsDir() && EsArchivoCapitulo(nombre)
+
+		if strings.HasPrefix(nombre, ".") || (!entrada.IsDir() && !esArchivo) {
+			continue
+		}
+
+		info, err := entrada.Info()
+		if err != nil {
+			continue
+		}
+
+		origen := path.Join(titulo, nombre)
+		vistos[origen] = true
+
+		ruta := filepath.Join(e.raiz, titulo, nombre)
+
+		c, actualizado := existentes[origen]
+		if actualizado {
+			if c.OrigenMod == info.ModTime().Unix() {
+				if c.Faltante {
+					err = repository.MarcarCapituloFaltante(e.db, c.ID, false)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			// El origen cambió: se vuelve a importar sobre el mismo capítulo
+			c.OrigenMod = info.ModTime().Unix()
+
+			if esArchivo {
+				err = importarArchivoDesdeDisco(e.db, c, ruta, ReimportarArchivoCapitulo)
+			} else {
+				_, err = ReimportarCarpetaCapitulo(e.db, c, ruta)
+			}
+		} else {
+			capitulo := models.Capitulo{
+				MangaID:   manga.ID,
+				Numero:    numeroDesdeNombre(strings.TrimSuffix(nombre, filepath.Ext(nombre))),
+				Origen:    origen,
+				OrigenMod: info.ModTime().Unix(),
+			}
+
+			if esArchivo {
+				err = importarArchivoDesdeDisco(e.db, capitulo, ruta, ImportarArchivoCapitulo)
+			} else {
+				_, err = ImportarCarpetaCapitulo(e.db, capitulo, ruta)
+			}
+		}
+
+		if err != nil {
+			res.Errores = append(res.Errores, fmt.Sprintf("%s: %v", origen, err))
+			continue
+		}
+
+		if actualizado {
+			res.CapitulosActualizados++
+		} else {
+			res.CapitulosNuevos++
+		}
+	}
+
+	return nil
+}
+
+// Marca capítulos cuyo origen ya no está en la biblioteca o cuyas imágenes
+// desaparecieron del almacenamiento, y desmarca los que volvieron
+func (e *Escaner) marcarFaltantes(existentes map[string]models.Capitulo, vistos map[string]bool, res *ResultadoEscaneo) error {
+	for origen, c := range existentes {
+		if !vistos[origen] && !c.Faltante {
+			err := repository.MarcarCapituloFaltante(e.db, c.ID, true)
+			if err != nil {
+				return err
+			}
+			res.CapitulosFaltantes++
+		}
+	}
+
+	mangas, err := repository.GetAllMangas(e.db)
+	if err != nil {
+		return err
+	}
+
+	for _, manga := range mangas {
+		capitulos, err := repository.GetCapitulosByManga(e.db, manga.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range capitulos {
+			if c.Origen != "" {
+				continue
+			}
+
+			_, errStat := os.Stat(filepath.Join(StaticDir, CapituloDir(c.MangaID, c.Numero)))
+			faltante := errStat != nil
+
+			if faltante == c.Faltante {
+				continue
+			}
+
+			err = repository.MarcarCapituloFaltante(e.db, c.ID, faltante)
+			if err != nil {
+				return err
+			}
+			if faltante {
+				res.CapitulosFaltantes++
+			}
+		}
+	}
+
+	return nil
+}
+
+// importar es ImportarArchivoCapitulo o ReimportarArchivoCapitulo
+func importarArchivoDesdeDisco(db *sql.DB, capitulo models.Capitulo, ruta string,
+	importar func(*sql.DB, models.Capitulo, io.ReaderAt, int64) (models.Capitulo, error)) error {
+	f, err := os.Open(ruta)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = importar(db, capitulo, f, info.Size())
+	return err
+}
+
+// Acepta .cbz y .zip como capítulos empaquetados
+func EsArchivoCapitulo(nombre string) bool {
+	ext := strings.ToLower(filepath.Ext(nombre))
+	return ext == ".cbz" || ext == ".zip"
+}
+
+var (
+	reNumeroCapitulo = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:c|ch|chap|chapter|cap|capitulo|capítulo)[\s._-]*(\d+)`)
+	reNumero         = regexp.MustCompile(`\d+`)
+)
+
+// Deduce el número de capítulo del nombre ("Serie c012", "Cap 5", "007").
+// Devuelve 0 si no hay número; entonces se usa ComicInfo.xml si existe
+func numeroDesdeNombre(nombre string) int {
+	if m := reNumeroCapitulo.FindStringSubmatch(nombre); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+
+	numeros := reNumero.FindAllString(nombre, -1)
+	if len(numeros) == 0 {
+		return 0
+	}
+
+	n, _ := strconv.Atoi(numeros[len(numeros)-1])
+	return n
+}
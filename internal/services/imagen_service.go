@@ -0,0 +1,275 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Carpeta de las variantes redimensionadas, fuera de StaticDir
+var ImagenesCacheDir = filepath.Join("cache", "imagenes")
+
+// Anchos que se generan. Se pide el menor que cubra el ancho solicitado
+// para no llenar la caché con un tamaño por cada valor de ?w=
+var AnchosImagen = []int{160, 240, 480, 800, 1200, 1600}
+
+// Por encima de esto no se decodifica (protege contra imágenes bomba)
+const maxPixeles = 80 << 20
+
+const calidadJPEG = 85
+
+var ErrImagenNoEncontrada = errors.New("imagen no encontrada")
+
+// Huella del contenido por archivo, para no releerlo en cada petición
+type huella struct {
+	mod    time.Time
+	tamano int64
+	hash   string
+}
+
+var (
+	huellasMu sync.Mutex
+	huellas   = map[string]huella{}
+)
+
+// Variante que se está generando; listo se cierra al terminar
+type generacion struct {
+	listo chan struct{}
+	ruta  string
+	err   error
+}
+
+var (
+	generandoMu sync.Mutex
+	generando   = map[string]*generacion{}
+
+	// Decodificaciones simultáneas, como mucho una por CPU: cada una puede
+	// ocupar cientos de MB
+	turnosVariante = make(chan struct{}, runtime.NumCPU())
+)
+
+// Ruta en disco de una imagen de StaticDir (uploads/... o default.jpg)
+func RutaImagen(archivo string) (string, error) {
+	archivo = path.Clean("/" + archivo)[1:]
+
+	if archivo == "" || !extensionesImagen[strings.ToLower(path.Ext(archivo))] {
+		return "", ErrImagenNoEncontrada
+	}
+
+	ruta := filepath.Join(StaticDir, filepath.FromSlash(archivo))
+
+	info, err := os.Stat(ruta)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", ErrImagenNoEncontrada
+	}
+
+	return ruta, nil
+}
+
+// Ancho de variante para el ancho pedido; 0 = la imagen original
+func AnchoVariante(ancho int) int {
+	if ancho <= 0 {
+		return 0
+	}
+
+	for _, a := range AnchosImagen {
+		if ancho <= a {
+			return a
+		}
+	}
+
+	return 0
+}
+
+// Devuelve la ruta en disco de archivo con como mucho ancho píxeles de
+// ancho, generándola y guardándola en caché si no existe, y el hash del
+// contenido original. Las imágenes más estrechas se sirven tal cual
+func VarianteImagen(archivo string, ancho int) (string, string, error) {
+	ruta, err := RutaImagen(archivo)
+	if err != nil {
+		return "", "", err
+	}
+
+	hash, err := HashImagen(ruta)
+	if err != nil {
+		return "", "", err
+	}
+
+	ancho = AnchoVariante(ancho)
+	if ancho == 0 {
+		return ruta, hash, nil
+	}
+
+	ruta, err = generarVariante(ruta, hash, ancho)
+	return ruta, hash, err
+}
+
+func generarVariante(ruta string, hash string, ancho int) (string, error) {
+	// JPEG para fotos y WebP (no hay codificador WebP en Go puro), PNG
+	// para conservar la transparencia de PNG y GIF
+	ext := ".jpg"
+	switch strings.ToLower(filepath.Ext(ruta)) {
+	case ".png", ".gif":
+		ext = ".png"
+	}
+
+	destino := filepath.Join(ImagenesCacheDir, hash[:2], fmt.Sprintf("%s-%d%s", hash, ancho, ext))
+	if _, err := os.Stat(destino); err == nil {
+		return destino, nil
+	}
+
+	// Si otra petición ya está generando esta variante se espera a la suya
+	generandoMu.Lock()
+	g, enCurso := generando[destino]
+	if !enCurso {
+		g = &generacion{listo: make(chan struct{})}
+		generando[destino] = g
+	}
+	generandoMu.Unlock()
+
+	if enCurso {
+		<-g.listo
+		return g.ruta, g.err
+	}
+
+	turnosVariante <- struct{}{}
+	g.ruta, g.err = crearVariante(ruta, destino, ancho, ext)
+	<-turnosVariante
+
+	generandoMu.Lock()
+	delete(generando, destino)
+	generandoMu.Unlock()
+	close(g.listo)
+
+	return g.ruta, g.err
+}
+
+func crearVariante(ruta string, destino string, ancho int, ext string) (string, error) {
+	f, err := os.Open(ruta)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+
+	if cfg.Width <= ancho {
+		return ruta, nil
+	}
+	if cfg.Width*cfg.Height > maxPixeles {
+		return "", ErrArchivoGrande
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	origen, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+
+	return destino, escribirVariante(destino, escalar(origen, ancho), ext)
+}
+
+// Reduce img a ancho píxeles de ancho manteniendo la proporción
+func escalar(img image.Image, ancho int) image.Image {
+	b := img.Bounds()
+
+	alto := b.Dy() * ancho / b.Dx()
+	if alto < 1 {
+		alto = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, ancho, alto))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	return dst
+}
+
+// Hash SHA-256 del contenido de ruta. Se recalcula solo si el archivo
+// cambió de tamaño o de fecha
+func HashImagen(ruta string) (string, error) {
+	info, err := os.Stat(ruta)
+	if err != nil {
+		return "", err
+	}
+
+	huellasMu.Lock()
+	h, ok := huellas[ruta]
+	huellasMu.Unlock()
+
+	if ok && h.mod.Equal(info.ModTime()) && h.tamano == info.Size() {
+		return h.hash, nil
+	}
+
+	f, err := os.Open(ruta)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	_, err = io.Copy(sum, f)
+	if err != nil {
+		return "", err
+	}
+
+	h = huella{mod: info.ModTime(), tamano: info.Size(), hash: hex.EncodeToString(sum.Sum(nil))}
+
+	huellasMu.Lock()
+	huellas[ruta] = h
+	huellasMu.Unlock()
+
+	return h.hash, nil
+}
+
+// Escribe en un temporal y renombra, para que dos peticiones simultáneas
+// nunca sirvan un archivo a medias
+func escribirVariante(destino string, img image.Image, ext string) error {
+	err := os.MkdirAll(filepath.Dir(destino), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(destino), "variante-*"+ext)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if ext == ".png" {
+		err = png.Encode(tmp, img)
+	} else {
+		err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: calidadJPEG})
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), destino)
+}
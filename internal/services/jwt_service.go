@@ -8,10 +8,11 @@ import (
 
 var jwtKey = []byte("inkzen_secret_key")
 
-func GenerateJWT(userID int) (string, error) {
+func GenerateJWT(userID int, rol string) (string, error) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID,
+		"rol":     rol,
 		"exp":     time.Now().Add(time.Hour * 24).Unix(),
 	})
 
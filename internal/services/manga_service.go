@@ -0,0 +1,20 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Graynie/InkZen/internal/repository"
+)
+
+// Borra el manga, sus registros relacionados y todo uploads/<manga>/
+func EliminarManga(db *sql.DB, mangaID int) error {
+	err := repository.DeleteManga(db, mangaID)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(filepath.Join(StaticDir, fmt.Sprintf("uploads/%d", mangaID)))
+}
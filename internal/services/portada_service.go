@@ -0,0 +1,129 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Graynie/InkZen/internal/repository"
+)
+
+// Tamaño máximo de una portada subida
+const MaxPortada = 10 << 20
+
+var extensionPorTipo = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Comprueba por su contenido que f es una imagen y devuelve su extensión.
+// Deja f de nuevo al principio
+func ExtensionImagen(f io.ReadSeeker) (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	ext, ok := extensionPorTipo[http.DetectContentType(buf[:n])]
+	if !ok {
+		return "", ErrNoEsImagen
+	}
+
+	return ext, nil
+}
+
+// Guarda la portada subida como uploads/<manga>/portada.<ext>
+func GuardarPortada(db *sql.DB, mangaID int, f io.ReadSeeker) error {
+	ext, err := ExtensionImagen(f)
+	if err != nil {
+		return err
+	}
+
+	return escribirPortada(db, mangaID, ext, f)
+}
+
+// Si el manga no tiene portada usa uploads/<manga>/portada.* si ya existe
+// o, si no, la primera página del capítulo de menor número
+func AsegurarPortada(db *sql.DB, mangaID int) error {
+	manga, err := repository.GetMangaByID(db, mangaID)
+	if err != nil {
+		return err
+	}
+
+	if manga.Portada != "" {
+		return nil
+	}
+
+	for ext := range extensionesImagen {
+		portada := fmt.Sprintf("uploads/%d/portada%s", mangaID, ext)
+		if _, err := os.Stat(filepath.Join(StaticDir, portada)); err == nil {
+			return repository.ActualizarPortada(db, mangaID, portada)
+		}
+	}
+
+	capitulos, err := repository.GetCapitulosByManga(db, mangaID)
+	if err != nil || len(capitulos) == 0 {
+		return err
+	}
+
+	paginas, err := repository.GetPaginasByCapitulo(db, capitulos[0].ID)
+	if err != nil || len(paginas) == 0 {
+		return err
+	}
+
+	f, err := os.Open(filepath.Join(StaticDir, paginas[0].Archivo))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return escribirPortada(db, mangaID, path.Ext(paginas[0].Archivo), f)
+}
+
+func escribirPortada(db *sql.DB, mangaID int, ext string, f io.Reader) error {
+	manga, err := repository.GetMangaByID(db, mangaID)
+	if err != nil {
+		return err
+	}
+
+	dir := fmt.Sprintf("uploads/%d", mangaID)
+	err = os.MkdirAll(filepath.Join(StaticDir, dir), 0755)
+	if err != nil {
+		return err
+	}
+
+	portada := dir + "/portada" + ext
+
+	out, err := os.Create(filepath.Join(StaticDir, portada))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, io.LimitReader(f, MaxPortada))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Una portada anterior con otra extensión queda huérfana
+	if manga.Portada != "" && manga.Portada != portada {
+		os.Remove(filepath.Join(StaticDir, manga.Portada))
+	}
+
+	return repository.ActualizarPortada(db, mangaID, portada)
+}
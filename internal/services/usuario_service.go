@@ -24,6 +24,8 @@ func (s *UsuarioService) PrepareUser(user models.Usuario) (models.Usuario, error
 	}
 
 	user.Password = hashedPassword
+	// El rol nunca lo elige quien se registra
+	user.Rol = models.RolLector
 	return user, nil
 }
 